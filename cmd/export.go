@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"e-commerce/internal/handler"
+	"e-commerce/internal/service"
+	"errors"
+	"flag"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const exportUsage = "usage: export orders|payments|users [-format csv|ndjson] [-o file] [name=value ...]"
+
+// runExport implements the export subcommand. Filters are name=value pairs
+// named like the query parameters of the export endpoints, for example
+//
+//	export orders -format ndjson -o orders.ndjson status=paid from=2024-07-01
+func runExport(exporter *service.Exporter, args []string) error {
+	if len(args) == 0 {
+		return errors.New(exportUsage)
+	}
+	resource := args[0]
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "csv", "output format, csv or ndjson")
+	output := flags.String("o", "", "output file, standard output by default")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	query := url.Values{"format": {*format}}
+	for _, arg := range flags.Args() {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return errors.New("filter " + arg + " must have the form name=value")
+		}
+		query.Add(name, value)
+	}
+	exportFormat, err := handler.ParseExportFormat(query)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	w := bufio.NewWriter(out)
+
+	switch resource {
+	case "orders":
+		err = exportOrders(exporter, w, exportFormat, query)
+	case "payments":
+		err = exportPayments(exporter, w, exportFormat, query)
+	case "users":
+		err = exporter.ExportUsers(w, exportFormat, handler.ParseUserFilter(query))
+	default:
+		return errors.New(exportUsage)
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func exportOrders(exporter *service.Exporter, w io.Writer, format string, query url.Values) error {
+	filter, err := handler.ParseOrderFilter(query)
+	if err != nil {
+		return err
+	}
+	filter.After = nil
+	return exporter.ExportOrders(w, format, filter)
+}
+
+func exportPayments(exporter *service.Exporter, w io.Writer, format string, query url.Values) error {
+	filter, err := handler.ParsePaymentFilter(query)
+	if err != nil {
+		return err
+	}
+	return exporter.ExportPayments(w, format, filter)
+}
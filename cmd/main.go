@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"e-commerce"
 	"e-commerce/internal/handler"
 	"e-commerce/internal/repository"
+	"e-commerce/internal/service"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 func main() {
@@ -25,7 +29,89 @@ func main() {
 	user := repository.NewUserRepository(db)
 	product := repository.NewProductRepository(db)
 	payment := repository.NewPaymentRepository(db)
-	handlers := handler.NewHandler(order, payment, user, product)
+	cart := repository.NewCartRepository(db)
+	coupon := repository.NewCouponRepository(db)
+	taxRate := repository.NewTaxRateRepository(db)
+	exchangeRate := repository.NewExchangeRateRepository(db)
+	shipping := repository.NewShippingRepository(db)
+	shipment := repository.NewShipmentRepository(db)
+	returns := repository.NewReturnRepository(db)
+	idempotency := repository.NewIdempotencyRepository(db)
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(service.NewExporter(order, payment, user), os.Args[2:]); err != nil {
+			log.Fatalf("Export failed: %v\n", err)
+		}
+		return
+	}
+
+	pricesIncludeTax, _ := strconv.ParseBool(os.Getenv("TAX_PRICES_INCLUDE_TAX"))
+	taxCountry := os.Getenv("TAX_DEFAULT_COUNTRY")
+	if taxCountry == "" {
+		taxCountry = "KZ"
+	}
+	tax := service.NewTableTaxCalculator(taxRate, pricesIncludeTax, taxCountry)
+
+	idempotencyTTL, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_TTL"))
+	if err != nil {
+		idempotencyTTL = 24 * time.Hour
+	}
+
+	purgeInterval, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_PURGE_INTERVAL"))
+	if err != nil || purgeInterval <= 0 {
+		purgeInterval = time.Hour
+	}
+	go service.NewIdempotencyKeyPurger(idempotency, purgeInterval).Start(context.Background())
+
+	currency := service.NewCurrencyConverter(exchangeRate)
+	orders := service.NewOrderService(product, coupon, shipping, tax, currency)
+
+	paymentTTL, err := time.ParseDuration(os.Getenv("ORDER_PAYMENT_TTL"))
+	if err != nil || paymentTTL <= 0 {
+		paymentTTL = 30 * time.Minute
+	}
+	expiryInterval, err := time.ParseDuration(os.Getenv("ORDER_EXPIRY_INTERVAL"))
+	if err != nil || expiryInterval <= 0 {
+		expiryInterval = time.Minute
+	}
+	expiry := service.NewOrderExpiryWorker(order, service.LogPublisher{}, paymentTTL, expiryInterval)
+	go expiry.Start(context.Background())
+
+	signer := service.NewHomebankSigner(os.Getenv("HOMEBANK_CALLBACK_SECRET"))
+	publicKeyRefresh, err := time.ParseDuration(os.Getenv("HOMEBANK_PUBLIC_KEY_REFRESH"))
+	if err != nil || publicKeyRefresh <= 0 {
+		publicKeyRefresh = time.Hour
+	}
+	homebankTimeout, err := time.ParseDuration(os.Getenv("HOMEBANK_TIMEOUT"))
+	if err != nil || homebankTimeout <= 0 {
+		homebankTimeout = 30 * time.Second
+	}
+	homebank := service.HomebankConfig{
+		TerminalID:       os.Getenv("HOMEBANK_TERMINAL_ID"),
+		CallbackURL:      os.Getenv("HOMEBANK_CALLBACK_URL"),
+		Signer:           signer,
+		PublicKeyURL:     os.Getenv("HOMEBANK_PUBLIC_KEY_URL"),
+		PublicKeyFile:    os.Getenv("HOMEBANK_PUBLIC_KEY_FILE"),
+		PublicKeyRefresh: publicKeyRefresh,
+		Timeout:          homebankTimeout,
+	}
+	gateway, err := service.NewPaymentGateway(os.Getenv("PAYMENT_GATEWAY"), homebank)
+	if err != nil {
+		log.Fatalf("Failed to configure payment gateway: %v\n", err)
+	}
+
+	pendingTimeout, err := time.ParseDuration(os.Getenv("PAYMENT_PENDING_TIMEOUT"))
+	if err != nil || pendingTimeout <= 0 {
+		pendingTimeout = 10 * time.Minute
+	}
+	reconcileInterval, err := time.ParseDuration(os.Getenv("PAYMENT_RECONCILE_INTERVAL"))
+	if err != nil || reconcileInterval <= 0 {
+		reconcileInterval = time.Minute
+	}
+	reconciler := service.NewPaymentReconciler(payment, gateway, pendingTimeout, reconcileInterval)
+	go reconciler.Start(context.Background())
+
+	handlers := handler.NewHandler(order, payment, user, product, cart, coupon, taxRate, exchangeRate, shipping, shipment, returns, idempotency, idempotencyTTL, orders, gateway, signer)
 
 	router := handlers.InitRoutes()
 	port := os.Getenv("PORT")
@@ -33,7 +119,7 @@ func main() {
 		port = "8080"
 	}
 
-	err := router.Run(":" + port)
+	err = router.Run(":" + port)
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
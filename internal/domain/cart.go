@@ -0,0 +1,65 @@
+package domain
+
+import "time"
+
+type Cart struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null;uniqueIndex" json:"user_id"`
+	Items     []CartItem `gorm:"foreignKey:CartID" json:"items"`
+	UpdatedAt time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type CartItem struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CartID    uint      `gorm:"not null;uniqueIndex:idx_cart_items_cart_product" json:"cart_id"`
+	ProductID uint      `gorm:"not null;uniqueIndex:idx_cart_items_cart_product" json:"product_id" validate:"required"`
+	Quantity  int       `gorm:"not null" json:"quantity" validate:"required,gt=0"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type CartItemUpdate struct {
+	Quantity int `json:"quantity" validate:"required,gt=0"`
+}
+
+type CartCheckout struct {
+	UserID           uint   `json:"user_id" validate:"required"`
+	CouponCode       string `json:"coupon_code"`
+	ShippingAddress  string `json:"shipping_address"`
+	ShippingCountry  string `json:"shipping_country" validate:"omitempty,len=2"`
+	ShippingRegion   string `json:"shipping_region"`
+	ShippingMethodID *uint  `json:"shipping_method_id"`
+	Currency         string `json:"currency" validate:"omitempty,iso4217"`
+}
+
+// CartChangedError is returned when the cart is changed, or checked out by
+// another request, while it is being checked out.
+type CartChangedError struct{}
+
+func (e *CartChangedError) Error() string {
+	return "cart changed during checkout"
+}
+
+// CartView is a cart priced with live catalog data.
+type CartView struct {
+	UserID   uint       `json:"user_id"`
+	Items    []CartLine `json:"items"`
+	Subtotal Money      `json:"subtotal"`
+	Warnings []string   `json:"warnings,omitempty"`
+}
+
+type CartLine struct {
+	ProductID uint   `json:"product_id"`
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice Money  `json:"unit_price"`
+	LineTotal Money  `json:"line_total"`
+	Available int    `json:"available"`
+	InStock   bool   `json:"in_stock"`
+}
+
+var CartBaseMessages = map[string]string{
+	"required": "is required",
+	"gt":       "must be greater than 0",
+	"len":      "must be a 2-letter country code",
+	"iso4217":  "must be an ISO 4217 currency code",
+}
@@ -0,0 +1,76 @@
+package domain
+
+import "time"
+
+const (
+	CouponTypePercentage   = "percentage"
+	CouponTypeFixedAmount  = "fixed_amount"
+	CouponTypeFreeShipping = "free_shipping"
+)
+
+// Coupon is a discount code. Value is the percentage taken off by percentage
+// coupons and Amount the sum taken off by fixed amount coupons. Zero limits
+// mean unlimited, and empty ProductIDs and Categories mean the coupon applies
+// to every product.
+type Coupon struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Code          string     `gorm:"not null;uniqueIndex" json:"code" validate:"required"`
+	Type          string     `gorm:"not null" json:"type" validate:"required,oneof=percentage fixed_amount free_shipping"`
+	Value         float64    `gorm:"not null;default:0" json:"value" validate:"gte=0"`
+	Amount        Money      `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	MinOrderValue Money      `gorm:"embedded;embeddedPrefix:min_order_value_" json:"min_order_value"`
+	StartsAt      *time.Time `json:"starts_at"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	UsageLimit    int        `gorm:"not null;default:0" json:"usage_limit" validate:"gte=0"`
+	PerUserLimit  int        `gorm:"not null;default:0" json:"per_user_limit" validate:"gte=0"`
+	UsageCount    int        `gorm:"not null;default:0" json:"usage_count"`
+	ProductIDs    []uint     `gorm:"serializer:json" json:"product_ids"`
+	Categories    []string   `gorm:"serializer:json" json:"categories"`
+	Disabled      bool       `gorm:"not null;default:false" json:"disabled"`
+	CreatedAt     time.Time  `gorm:"not null;autoCreateTime" json:"created_at"`
+}
+
+// AppliesTo reports whether the coupon's product and category restrictions
+// allow it to discount the product.
+func (c *Coupon) AppliesTo(product *Product) bool {
+	if len(c.ProductIDs) == 0 && len(c.Categories) == 0 {
+		return true
+	}
+	for _, id := range c.ProductIDs {
+		if id == product.ID {
+			return true
+		}
+	}
+	for _, category := range c.Categories {
+		if category == product.Category {
+			return true
+		}
+	}
+	return false
+}
+
+type CouponRedemption struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CouponID  uint      `gorm:"not null;index:idx_coupon_redemptions_coupon_user" json:"coupon_id"`
+	UserID    uint      `gorm:"not null;index:idx_coupon_redemptions_coupon_user" json:"user_id"`
+	OrderID   uint      `gorm:"not null;uniqueIndex" json:"order_id"`
+	Discount  Money     `gorm:"embedded;embeddedPrefix:discount_" json:"discount"`
+	CreatedAt time.Time `gorm:"not null;autoCreateTime" json:"created_at"`
+}
+
+// CouponError is returned when a coupon code cannot be applied to an order.
+type CouponError struct {
+	Code   string
+	Reason string
+}
+
+func (e *CouponError) Error() string {
+	return "coupon " + e.Code + " " + e.Reason
+}
+
+var CouponBaseMessages = map[string]string{
+	"required": "is required",
+	"oneof":    "must be either 'percentage', 'fixed_amount' or 'free_shipping'",
+	"gte":      "must be greater than or equal to 0",
+	"iso4217":  "must be an ISO 4217 currency code",
+}
@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+const OrderEventExpired = "order.expired"
+
+// OrderEvent describes something that happened to an order, for consumers
+// outside the request that caused it.
+type OrderEvent struct {
+	Type       string    `json:"type"`
+	OrderID    uint      `json:"order_id"`
+	UserID     uint      `json:"user_id"`
+	Status     string    `json:"status"`
+	Reason     string    `json:"reason,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
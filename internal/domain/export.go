@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatNDJSON = "ndjson"
+)
+
+var ErrExportFormat = errors.New("format must be either 'csv' or 'ndjson'")
+
+// ExportRow is one line of a data export. CSVRecord returns the values in the
+// order of the header of the export.
+type ExportRow interface {
+	CSVRecord() []string
+}
+
+// PaymentFilter selects payments for the payment export. Nil fields do not
+// filter.
+type PaymentFilter struct {
+	UserID  *uint
+	OrderID *uint
+	From    *time.Time
+	To      *time.Time
+}
+
+// UserFilter selects users for the user export. Empty fields do not filter.
+type UserFilter struct {
+	Name  string
+	Email string
+	Role  string
+}
+
+var OrderExportHeader = []string{
+	"order_id", "order_date", "status", "user_id", "user_name", "user_email", "currency",
+	"product_id", "product_name", "quantity", "unit_price", "line_total", "discount", "total_price", "base_total",
+}
+
+// OrderExportRow is an order item joined with its order and the order's user.
+type OrderExportRow struct {
+	OrderID     uint      `json:"order_id"`
+	OrderDate   time.Time `json:"order_date"`
+	Status      string    `json:"status"`
+	UserID      uint      `json:"user_id"`
+	UserName    string    `json:"user_name"`
+	UserEmail   string    `json:"user_email"`
+	ProductID   uint      `json:"product_id"`
+	ProductName string    `json:"product_name"`
+	Quantity    int       `json:"quantity"`
+	UnitPrice   Money     `gorm:"embedded;embeddedPrefix:unit_price_" json:"unit_price"`
+	LineTotal   Money     `gorm:"embedded;embeddedPrefix:line_total_" json:"line_total"`
+	Discount    Money     `gorm:"embedded;embeddedPrefix:discount_" json:"discount"`
+	TotalPrice  Money     `gorm:"embedded;embeddedPrefix:total_price_" json:"total_price"`
+	BaseTotal   Money     `gorm:"embedded;embeddedPrefix:base_total_" json:"base_total"`
+}
+
+func (r *OrderExportRow) CSVRecord() []string {
+	return []string{
+		formatUint(r.OrderID), r.OrderDate.UTC().Format(time.RFC3339), r.Status,
+		formatUint(r.UserID), r.UserName, r.UserEmail, r.TotalPrice.Currency,
+		formatUint(r.ProductID), r.ProductName, strconv.Itoa(r.Quantity),
+		r.UnitPrice.Decimal(), r.LineTotal.Decimal(), r.Discount.Decimal(), r.TotalPrice.Decimal(), r.BaseTotal.Decimal(),
+	}
+}
+
+var PaymentExportHeader = []string{
+	"payment_id", "payment_date", "status", "order_id", "user_id", "user_name", "user_email", "amount", "currency", "transaction_id",
+}
+
+// PaymentExportRow is a payment joined with its user.
+type PaymentExportRow struct {
+	PaymentID     uint      `json:"payment_id"`
+	PaymentDate   time.Time `json:"payment_date"`
+	Status        string    `json:"status"`
+	OrderID       uint      `json:"order_id"`
+	UserID        uint      `json:"user_id"`
+	UserName      string    `json:"user_name"`
+	UserEmail     string    `json:"user_email"`
+	Amount        Money     `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	TransactionID string    `json:"transaction_id"`
+}
+
+func (r *PaymentExportRow) CSVRecord() []string {
+	return []string{
+		formatUint(r.PaymentID), r.PaymentDate.UTC().Format(time.RFC3339), r.Status, formatUint(r.OrderID),
+		formatUint(r.UserID), r.UserName, r.UserEmail, r.Amount.Decimal(), r.Amount.Currency, r.TransactionID,
+	}
+}
+
+var UserExportHeader = []string{
+	"user_id", "name", "email", "address", "role", "registration_date", "order_count",
+}
+
+// UserExportRow is a user with the number of orders they have placed.
+type UserExportRow struct {
+	UserID           uint      `json:"user_id"`
+	Name             string    `json:"name"`
+	Email            string    `json:"email"`
+	Address          string    `json:"address"`
+	Role             string    `json:"role"`
+	RegistrationDate time.Time `json:"registration_date"`
+	OrderCount       int       `json:"order_count"`
+}
+
+func (r *UserExportRow) CSVRecord() []string {
+	return []string{
+		formatUint(r.UserID), r.Name, r.Email, r.Address, r.Role,
+		r.RegistrationDate.UTC().Format(time.RFC3339), strconv.Itoa(r.OrderCount),
+	}
+}
+
+func formatUint(value uint) string {
+	return strconv.FormatUint(uint64(value), 10)
+}
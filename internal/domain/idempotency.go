@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+// IdempotencyKey stores the outcome of a request sent with an
+// Idempotency-Key header so that retries can be answered with the original
+// response instead of being executed again.
+type IdempotencyKey struct {
+	Key          string    `gorm:"primaryKey;size:255"`
+	Fingerprint  string    `gorm:"not null"`
+	Completed    bool      `gorm:"not null;default:false"`
+	StatusCode   int       `gorm:"not null;default:0"`
+	ContentType  string    `gorm:"not null;default:''"`
+	ResponseBody []byte    `gorm:"type:bytea"`
+	CreatedAt    time.Time `gorm:"not null;autoCreateTime"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+}
@@ -0,0 +1,203 @@
+package domain
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BaseCurrency is the currency catalog prices default to and reporting
+// amounts are kept in.
+const BaseCurrency = "KZT"
+
+// currencyExponents lists the ISO 4217 currencies whose minor unit is not
+// one hundredth of the major unit.
+var currencyExponents = map[string]int{
+	"BHD": 3, "BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "IQD": 3, "ISK": 0,
+	"JOD": 3, "JPY": 0, "KMF": 0, "KRW": 0, "KWD": 3, "LYD": 3, "OMR": 3,
+	"PYG": 0, "RWF": 0, "TND": 3, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+// CurrencyExponent returns the number of decimal places of the currency's
+// minor unit.
+func CurrencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[currency]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// Money is an amount in the minor units of its currency, e.g. tiyn for KZT
+// or cents for USD. Arithmetic between amounts of different currencies panics,
+// except that an amount without a currency or a zero amount takes the
+// currency of the other.
+type Money struct {
+	Amount   int64  `gorm:"not null;default:0" json:"amount" validate:"gte=0"`
+	Currency string `gorm:"size:3;not null;default:KZT" json:"currency" validate:"omitempty,iso4217"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney reads a decimal amount in major units, such as "1500.50".
+func ParseMoney(value, currency string) (Money, error) {
+	exponent := CurrencyExponent(currency)
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(value), ".")
+	if len(fraction) > exponent {
+		return Money{}, errors.New("too many decimal places for " + currency)
+	}
+
+	amount, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", exponent-len(fraction)), 10, 64)
+	if err != nil || whole == "" || strings.ContainsAny(value, "-+") {
+		return Money{}, errors.New("invalid amount " + strconv.Quote(value))
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.currencyWith(other)}
+}
+
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.currencyWith(other)}
+}
+
+func (m Money) Times(n int) Money {
+	return Money{Amount: m.Amount * int64(n), Currency: m.Currency}
+}
+
+// Min returns the smaller of the two amounts.
+func (m Money) Min(other Money) Money {
+	if other.Amount < m.Amount {
+		return Money{Amount: other.Amount, Currency: m.currencyWith(other)}
+	}
+	return Money{Amount: m.Amount, Currency: m.currencyWith(other)}
+}
+
+// Scale returns m * numerator / denominator, rounded half away from zero.
+func (m Money) Scale(numerator, denominator int64) Money {
+	if denominator == 0 {
+		return Money{Currency: m.Currency}
+	}
+	ratio := new(big.Rat).SetFrac(big.NewInt(numerator), big.NewInt(denominator))
+	return Money{Amount: roundRat(ratio.Mul(ratio, new(big.Rat).SetInt64(m.Amount))), Currency: m.Currency}
+}
+
+// Percent returns the given percentage of the amount, rounded half away
+// from zero.
+func (m Money) Percent(percent float64) Money {
+	ratio := new(big.Rat).SetFloat64(percent)
+	if ratio == nil {
+		return Money{Currency: m.Currency}
+	}
+	ratio.Mul(ratio, new(big.Rat).SetInt64(m.Amount))
+	return Money{Amount: roundRat(ratio.Quo(ratio, big.NewRat(100, 1))), Currency: m.Currency}
+}
+
+// Convert returns the amount in another currency, where rate is the price of
+// one major unit of m's currency in the target currency.
+func (m Money) Convert(currency string, rate float64) Money {
+	ratio := new(big.Rat).SetFloat64(rate)
+	if ratio == nil {
+		return Money{Currency: currency}
+	}
+	ratio.Mul(ratio, new(big.Rat).SetInt64(m.Amount))
+
+	shift := CurrencyExponent(currency) - CurrencyExponent(m.Currency)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		ratio.Mul(ratio, scale)
+	} else {
+		ratio.Quo(ratio, scale)
+	}
+	return Money{Amount: roundRat(ratio), Currency: currency}
+}
+
+// Decimal formats the amount in major units, e.g. "1500.50".
+func (m Money) Decimal() string {
+	exponent := CurrencyExponent(m.Currency)
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	digits := strconv.FormatInt(amount, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// currencyWith returns the currency of an operation on m and other. Mixing
+// currencies is a bug that would give a wrong amount, so it panics.
+func (m Money) currencyWith(other Money) string {
+	switch {
+	case m.Currency == other.Currency, other.Currency == "":
+		return m.Currency
+	case m.Currency == "":
+		return other.Currency
+	case other.Amount == 0:
+		return m.Currency
+	case m.Amount == 0:
+		return other.Currency
+	}
+	panic("money: cannot combine " + m.Currency + " and " + other.Currency)
+}
+
+// roundRat rounds to the nearest integer, halves away from zero.
+func roundRat(r *big.Rat) int64 {
+	quotient, remainder := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if remainder.Abs(remainder).Lsh(remainder, 1).Cmp(r.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(r.Num().Sign())))
+	}
+	return quotient.Int64()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// ExchangeRate is the price of one major unit of From in To.
+type ExchangeRate struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	From      string    `gorm:"column:from_currency;size:3;not null;uniqueIndex:idx_exchange_rates_pair" json:"from" validate:"required,iso4217"`
+	To        string    `gorm:"column:to_currency;size:3;not null;uniqueIndex:idx_exchange_rates_pair" json:"to" validate:"required,iso4217"`
+	Rate      float64   `gorm:"type:numeric(20,10);not null" json:"rate" validate:"gt=0"`
+	UpdatedAt time.Time `gorm:"not null;autoUpdateTime" json:"updated_at"`
+}
+
+// ExchangeRateError is returned when there is no stored rate between two
+// currencies.
+type ExchangeRateError struct {
+	From string
+	To   string
+}
+
+func (e *ExchangeRateError) Error() string {
+	return "no exchange rate from " + e.From + " to " + e.To
+}
+
+var ExchangeRateBaseMessages = map[string]string{
+	"required": "is required",
+	"iso4217":  "must be an ISO 4217 currency code",
+	"gt":       "must be greater than 0",
+}
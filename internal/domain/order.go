@@ -3,15 +3,222 @@ package domain
 import "time"
 
 type Order struct {
-	ID         uint      `gorm:"primaryKey"`
-	UserID     uint      `json:"user_id" validate:"required"`
-	ProductIDs []uint    `gorm:"-" json:"product_ids" validate:"required"`
-	TotalPrice float64   `json:"total_price" validate:"required"`
-	OrderDate  time.Time `gorm:"not null;autoCreateTime"`
-	Status     string    `validate:"required,oneof=new processing completed"`
+	ID               uint           `gorm:"primaryKey"`
+	UserID           uint           `gorm:"index:idx_orders_user_date,priority:1" json:"user_id" validate:"required"`
+	ProductIDs       []uint         `gorm:"-" json:"product_ids" validate:"required_without=Items"`
+	Items            []OrderItem    `gorm:"foreignKey:OrderID" json:"items" validate:"omitempty,dive"`
+	Currency         string         `gorm:"size:3;not null;default:KZT" json:"currency" validate:"omitempty,iso4217"`
+	Subtotal         Money          `gorm:"embedded;embeddedPrefix:subtotal_" json:"subtotal"`
+	DiscountTotal    Money          `gorm:"embedded;embeddedPrefix:discount_total_" json:"discount_total"`
+	TaxTotal         Money          `gorm:"embedded;embeddedPrefix:tax_total_" json:"tax_total"`
+	CouponID         *uint          `json:"coupon_id"`
+	CouponCode       string         `json:"coupon_code"`
+	TaxLines         []OrderTaxLine `gorm:"foreignKey:OrderID" json:"tax_lines"`
+	ShippingAddress  string         `json:"shipping_address"`
+	ShippingCountry  string         `json:"shipping_country" validate:"omitempty,len=2"`
+	ShippingRegion   string         `json:"shipping_region"`
+	ShippingMethodID *uint          `json:"shipping_method_id"`
+	ShippingTotal    Money          `gorm:"embedded;embeddedPrefix:shipping_total_" json:"shipping_total"`
+	ShippingDiscount Money          `gorm:"embedded;embeddedPrefix:shipping_discount_" json:"shipping_discount"`
+	TotalPrice       Money          `gorm:"embedded;embeddedPrefix:total_price_" json:"total_price"`
+	BaseTotal        Money          `gorm:"embedded;embeddedPrefix:base_total_" json:"base_total"`
+	ExchangeRate     float64        `gorm:"type:numeric(20,10);not null;default:1" json:"exchange_rate"`
+	OrderDate        time.Time      `gorm:"not null;autoCreateTime;index;index:idx_orders_user_date,priority:2"`
+	Status           string         `gorm:"not null;default:pending_payment;index"`
+}
+
+type OrderItem struct {
+	ID                uint  `gorm:"primaryKey" json:"id"`
+	OrderID           uint  `gorm:"not null;index" json:"order_id"`
+	ProductID         uint  `gorm:"not null;index" json:"product_id" validate:"required"`
+	Quantity          int   `gorm:"not null" json:"quantity" validate:"required,gt=0"`
+	FulfilledQuantity int   `gorm:"not null;default:0" json:"fulfilled_quantity"`
+	Backordered       int   `gorm:"not null;default:0" json:"backordered"`
+	UnitPrice         Money `gorm:"embedded;embeddedPrefix:unit_price_" json:"unit_price"`
+	LineTotal         Money `gorm:"embedded;embeddedPrefix:line_total_" json:"line_total"`
+	Discount          Money `gorm:"embedded;embeddedPrefix:discount_" json:"discount"`
+}
+
+// Unfulfilled is the number of units of the line that have not shipped yet.
+func (i *OrderItem) Unfulfilled() int {
+	return i.Quantity - i.FulfilledQuantity
+}
+
+// Shippable is the number of unshipped units of the line that have been
+// allocated from stock.
+func (i *OrderItem) Shippable() int {
+	return i.Unfulfilled() - i.Backordered
+}
+
+// IsFulfilled reports whether every unit of every line has shipped.
+func (o *Order) IsFulfilled() bool {
+	for i := range o.Items {
+		if o.Items[i].Unfulfilled() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeItems folds the legacy ProductIDs list into Items, one unit per ID,
+// and merges duplicate product lines.
+func (o *Order) NormalizeItems() {
+	items := make([]OrderItem, 0, len(o.Items)+len(o.ProductIDs))
+	index := make(map[uint]int)
+
+	add := func(productID uint, quantity int) {
+		if i, ok := index[productID]; ok {
+			items[i].Quantity += quantity
+			return
+		}
+		index[productID] = len(items)
+		items = append(items, OrderItem{ProductID: productID, Quantity: quantity})
+	}
+
+	for _, item := range o.Items {
+		add(item.ProductID, item.Quantity)
+	}
+	for _, productID := range o.ProductIDs {
+		add(productID, 1)
+	}
+
+	o.Items = items
+	o.FillProductIDs()
+}
+
+// FillProductIDs rebuilds ProductIDs from the persisted items so that clients
+// still relying on the flat list keep working.
+func (o *Order) FillProductIDs() {
+	o.ProductIDs = make([]uint, 0, len(o.Items))
+	for _, item := range o.Items {
+		o.ProductIDs = append(o.ProductIDs, item.ProductID)
+	}
+}
+
+const (
+	OrderStatusPendingPayment     = "pending_payment"
+	OrderStatusPaid               = "paid"
+	OrderStatusProcessing         = "processing"
+	OrderStatusPartiallyFulfilled = "partially_fulfilled"
+	OrderStatusShipped            = "shipped"
+	OrderStatusDelivered          = "delivered"
+	OrderStatusCompleted          = "completed"
+	OrderStatusCancelled          = "cancelled"
+	OrderStatusRefunded           = "refunded"
+	OrderStatusPartiallyRefunded  = "partially_refunded"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Cancelled and refunded orders are final, an order can no longer be
+// cancelled once every unit has shipped, and a partially refunded order can
+// only be refunded in full. Only delivered and completed orders become
+// partially refunded; orders still being fulfilled keep their status and
+// the partial refund is recorded on the payment.
+var orderTransitions = map[string][]string{
+	OrderStatusPendingPayment:     {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:               {OrderStatusProcessing, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusProcessing:         {OrderStatusPartiallyFulfilled, OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusPartiallyFulfilled: {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:            {OrderStatusDelivered},
+	OrderStatusDelivered:          {OrderStatusCompleted, OrderStatusRefunded, OrderStatusPartiallyRefunded},
+	OrderStatusCompleted:          {OrderStatusRefunded, OrderStatusPartiallyRefunded},
+	OrderStatusPartiallyRefunded:  {OrderStatusRefunded},
+}
+
+func CanTransitionOrder(from, to string) bool {
+	for _, status := range orderTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+type OrderTransition struct {
+	Status string `json:"status" validate:"required,oneof=pending_payment paid processing partially_fulfilled shipped delivered completed cancelled refunded partially_refunded"`
+	Actor  string `json:"actor" validate:"required"`
+	Reason string `json:"reason"`
+}
+
+type OrderCancellation struct {
+	Actor  string `json:"actor" validate:"required"`
+	Reason string `json:"reason"`
+}
+
+const (
+	OrderSortDate  = "order_date"
+	OrderSortTotal = "total_price"
+	OrderSortID    = "id"
+)
+
+// OrderFilter selects orders for the order list. Nil and empty fields do not
+// filter. Results are ordered by SortBy and then by ID, and After continues
+// a previous page.
+type OrderFilter struct {
+	UserID     *uint
+	Statuses   []string
+	From       *time.Time
+	To         *time.Time
+	MinTotal   *Money
+	MaxTotal   *Money
+	ProductID  *uint
+	SortBy     string
+	Descending bool
+	After      *OrderCursor
+	Limit      int
+}
+
+// OrderCursor identifies the last order of a page by its sort key.
+type OrderCursor struct {
+	SortBy     string    `json:"s"`
+	Descending bool      `json:"d"`
+	OrderDate  time.Time `json:"od,omitempty"`
+	BaseTotal  int64     `json:"bt,omitempty"`
+	ID         uint      `json:"id"`
+}
+
+type OrderStatusHistory struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	OrderID    uint      `gorm:"not null;index" json:"order_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `gorm:"not null" json:"to_status"`
+	Actor      string    `gorm:"not null" json:"actor"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `gorm:"not null;autoCreateTime" json:"created_at"`
+}
+
+func (OrderStatusHistory) TableName() string {
+	return "order_status_history"
+}
+
+// InvalidTransitionError is returned when a status change is not allowed by
+// the order lifecycle.
+type InvalidTransitionError struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return "cannot move order from '" + e.From + "' to '" + e.To + "'"
+}
+
+// OrderDeleteError is returned when an order that still holds stock, coupon
+// uses or money movements is deleted. Only cancelled orders without
+// payments, shipments or returns can be deleted.
+type OrderDeleteError struct {
+	Reason string
+}
+
+func (e *OrderDeleteError) Error() string {
+	return "order cannot be deleted: " + e.Reason
 }
 
 var OrderBaseMessages = map[string]string{
-	"required": "is required",
-	"oneof":    "must be either 'new', 'processing' or 'completed'",
+	"required":         "is required",
+	"required_without": "is required when items are not provided",
+	"gt":               "must be greater than 0",
+	"gte":              "must be greater than or equal to 0",
+	"len":              "must be a 2-letter country code",
+	"iso4217":          "must be an ISO 4217 currency code",
+	"oneof":            "must be one of 'pending_payment', 'paid', 'processing', 'partially_fulfilled', 'shipped', 'delivered', 'completed', 'cancelled', 'refunded' or 'partially_refunded'",
 }
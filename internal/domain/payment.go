@@ -2,11 +2,124 @@ package domain
 
 import "time"
 
+const (
+	PaymentStatusPending           = "pending"
+	PaymentStatusAuthorized        = "authorized"
+	PaymentStatusCaptured          = "captured"
+	PaymentStatusFailed            = "failed"
+	PaymentStatusRefunded          = "refunded"
+	PaymentStatusPartiallyRefunded = "partially_refunded"
+	PaymentStatusVoided            = "voided"
+)
+
+// paymentTransitions lists the statuses a payment may move to from each
+// status. Failed, voided and fully refunded payments are final.
+var paymentTransitions = map[string][]string{
+	PaymentStatusPending:           {PaymentStatusAuthorized, PaymentStatusCaptured, PaymentStatusFailed},
+	PaymentStatusAuthorized:        {PaymentStatusCaptured, PaymentStatusVoided, PaymentStatusFailed},
+	PaymentStatusCaptured:          {PaymentStatusPartiallyRefunded, PaymentStatusRefunded},
+	PaymentStatusPartiallyRefunded: {PaymentStatusPartiallyRefunded, PaymentStatusRefunded},
+}
+
+func CanTransitionPayment(from, to string) bool {
+	for _, status := range paymentTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ActivePaymentStatuses are the statuses of payments that have not failed or
+// been voided. Only these count towards an order's paid amount.
+var ActivePaymentStatuses = []string{PaymentStatusPending, PaymentStatusAuthorized, PaymentStatusCaptured, PaymentStatusPartiallyRefunded, PaymentStatusRefunded}
+
 type Payment struct {
 	ID            uint      `gorm:"primaryKey"`
 	UserID        uint      `json:"user_id" gorm:"not null"`
-	OrderID       uint      `json:"order_id" gorm:"not null"`
-	Amount        float64   `gorm:"not null"`
+	OrderID       uint      `json:"order_id" gorm:"not null;index"`
+	Amount        Money     `gorm:"embedded;embeddedPrefix:amount_"`
 	PaymentDate   time.Time `gorm:"autoCreateTime"`
-	PaymentStatus string    `json:"payment_status" gorm:"-"`
+	TransactionID string    `json:"transaction_id" gorm:"index"`
+	InvoiceID     string    `json:"invoice_id" gorm:"uniqueIndex:idx_payments_invoice,where:invoice_id <> ''"`
+	PaymentStatus string    `json:"payment_status" gorm:"column:status;not null;default:pending;index"`
+	FailureReason string    `json:"failure_reason"`
+}
+
+// PaymentCard identifies the card to charge: either a cryptogram the client
+// encrypted with the provider's public key or the token of a saved card. Raw
+// card numbers are never accepted.
+type PaymentCard struct {
+	Cryptogram string `json:"cryptogram" validate:"omitempty,base64"`
+	CardToken  string `json:"card_token"`
+}
+
+// PaymentCreate is a request to pay for an order. The card is only passed
+// on to the gateway and never stored.
+type PaymentCreate struct {
+	UserID  uint  `json:"user_id"`
+	OrderID uint  `json:"order_id" validate:"required"`
+	Amount  Money `json:"amount"`
+	PaymentCard
+}
+
+var PaymentBaseMessages = map[string]string{
+	"required": "is required",
+	"base64":   "must be a base64 encoded cryptogram",
+}
+
+// PaymentEvent records a payment status change.
+type PaymentEvent struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	PaymentID  uint      `gorm:"not null;index" json:"payment_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `gorm:"not null" json:"to_status"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `gorm:"not null;autoCreateTime" json:"created_at"`
+}
+
+// InvalidPaymentTransitionError is returned when a status change is not
+// allowed by the payment lifecycle.
+type InvalidPaymentTransitionError struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (e *InvalidPaymentTransitionError) Error() string {
+	return "cannot move payment from '" + e.From + "' to '" + e.To + "'"
+}
+
+// PaymentAmountError is returned when a payment does not match the
+// outstanding balance of its order. A zero Outstanding means the order has
+// already been paid.
+type PaymentAmountError struct {
+	Outstanding Money
+}
+
+func (e *PaymentAmountError) Error() string {
+	if e.Outstanding.Amount <= 0 {
+		return "order has already been paid"
+	}
+	return "payment amount must equal the outstanding balance of " + e.Outstanding.String()
+}
+
+// PaymentDeleteError is returned when a payment that holds or moved money is
+// deleted. Only failed and voided payments can be deleted.
+type PaymentDeleteError struct {
+	Status string
+}
+
+func (e *PaymentDeleteError) Error() string {
+	return "payment in status '" + e.Status + "' cannot be deleted"
+}
+
+// PaymentGatewayError is returned when the payment provider rejects an
+// operation.
+type PaymentGatewayError struct {
+	Operation string
+	Reason    string
+}
+
+func (e *PaymentGatewayError) Error() string {
+	return e.Operation + " failed: " + e.Reason
 }
@@ -1,19 +1,77 @@
 package domain
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
 
+// Product is a catalog item. Products that allow backorders, or are on
+// pre-order until PreorderReleaseDate, keep selling once Quantity runs out;
+// the missing units are recorded in Backordered, up to MaxBackorder units
+// when it is set.
 type Product struct {
-	ID          uint      `gorm:"primaryKey"`
-	Name        string    `gorm:"not null" validate:"required"`
-	Description string    `gorm:"not null" validate:"required"`
-	Price       float64   `gorm:"not null" validate:"required,gt=0"`
-	Category    string    `gorm:"not null" validate:"required"`
-	Quantity    int       `gorm:"not null" json:"quantity" validate:"required,gte=0"`
-	CreatedAt   time.Time `gorm:"not null;autoCreateTime"`
+	ID                  uint       `gorm:"primaryKey"`
+	Name                string     `gorm:"not null" validate:"required"`
+	Description         string     `gorm:"not null" validate:"required"`
+	Price               Money      `gorm:"embedded;embeddedPrefix:price_" validate:"positive_money"`
+	Category            string     `gorm:"not null" validate:"required"`
+	Quantity            int        `gorm:"not null" json:"quantity" validate:"gte=0"`
+	Weight              float64    `gorm:"not null;default:0" json:"weight" validate:"gte=0"`
+	AllowBackorder      bool       `gorm:"not null;default:false" json:"allow_backorder"`
+	PreorderReleaseDate *time.Time `json:"preorder_release_date"`
+	MaxBackorder        int        `gorm:"not null;default:0" json:"max_backorder" validate:"gte=0"`
+	Backordered         int        `gorm:"not null;default:0" json:"backordered"`
+	CreatedAt           time.Time  `gorm:"not null;autoCreateTime"`
+}
+
+// AcceptsBackorders reports whether the product can be ordered beyond its
+// stock at the given time.
+func (p *Product) AcceptsBackorders(now time.Time) bool {
+	return p.AllowBackorder || (p.PreorderReleaseDate != nil && p.PreorderReleaseDate.After(now))
+}
+
+// BackorderCapacity is the number of units that can still be backordered,
+// or -1 when there is no limit.
+func (p *Product) BackorderCapacity(now time.Time) int {
+	switch {
+	case !p.AcceptsBackorders(now):
+		return 0
+	case p.MaxBackorder == 0:
+		return -1
+	default:
+		return max(p.MaxBackorder-p.Backordered, 0)
+	}
 }
 
 var ProductBaseMessages = map[string]string{
 	"required": "is required",
 	"gt":       "must be greater than 0",
 	"gte":      "must be greater than or equal to 0",
+	"iso4217":  "must be an ISO 4217 currency code",
+
+	"positive_money": "must be greater than 0",
+}
+
+type ProductNotFoundError struct {
+	ProductID uint
+}
+
+func (e *ProductNotFoundError) Error() string {
+	return "Product with ID " + strconv.Itoa(int(e.ProductID)) + " not found"
+}
+
+type StockShortage struct {
+	ProductID uint `json:"product_id"`
+	Requested int  `json:"requested"`
+	Available int  `json:"available"`
+}
+
+// InsufficientStockError is returned when an order asks for more units than
+// are in stock for one or more products.
+type InsufficientStockError struct {
+	Items []StockShortage `json:"items"`
+}
+
+func (e *InsufficientStockError) Error() string {
+	return "insufficient stock"
 }
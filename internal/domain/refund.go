@@ -0,0 +1,44 @@
+package domain
+
+import "time"
+
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusCompleted = "completed"
+	RefundStatusFailed    = "failed"
+)
+
+// Refund is money returned to the card of a captured payment. A refund is
+// pending while the gateway processes it; pending and completed refunds
+// count against the payment's refundable amount. Refunds made for a return
+// are linked to it.
+type Refund struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	PaymentID       uint      `gorm:"not null;index" json:"payment_id"`
+	ReturnRequestID *uint     `gorm:"index" json:"return_request_id,omitempty"`
+	Amount          Money     `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	Reason          string    `json:"reason"`
+	Status          string    `gorm:"not null;default:pending" json:"status"`
+	FailureReason   string    `json:"failure_reason,omitempty"`
+	CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type RefundRequest struct {
+	Amount Money  `json:"amount" validate:"positive_money"`
+	Reason string `json:"reason" validate:"required"`
+}
+
+// RefundLimitError is returned when a refund would exceed what is left of
+// the captured amount.
+type RefundLimitError struct {
+	Refundable Money
+}
+
+func (e *RefundLimitError) Error() string {
+	return "refund exceeds the refundable amount of " + e.Refundable.String()
+}
+
+var RefundBaseMessages = map[string]string{
+	"required":       "is required",
+	"positive_money": "must be greater than 0",
+}
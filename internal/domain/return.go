@@ -0,0 +1,115 @@
+package domain
+
+import "time"
+
+const (
+	ReturnStatusRequested = "requested"
+	ReturnStatusApproved  = "approved"
+	ReturnStatusRejected  = "rejected"
+	ReturnStatusReceived  = "received"
+	ReturnStatusRefunding = "refunding"
+	ReturnStatusRefunded  = "refunded"
+)
+
+// returnTransitions lists the statuses a return may move to from each status.
+// Rejected and refunded returns are final. A return is refunding while its
+// refunds are sent to the payment provider.
+var returnTransitions = map[string][]string{
+	ReturnStatusRequested: {ReturnStatusApproved, ReturnStatusRejected},
+	ReturnStatusApproved:  {ReturnStatusReceived, ReturnStatusRejected},
+	ReturnStatusReceived:  {ReturnStatusRefunding},
+	ReturnStatusRefunding: {ReturnStatusRefunded},
+}
+
+func CanTransitionReturn(from, to string) bool {
+	for _, status := range returnTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+type ReturnRequest struct {
+	ID           uint         `gorm:"primaryKey" json:"id"`
+	OrderID      uint         `gorm:"not null;index" json:"order_id" validate:"required"`
+	UserID       uint         `gorm:"not null;index" json:"user_id"`
+	Status       string       `gorm:"not null;default:requested;index" json:"status"`
+	Reason       string       `gorm:"not null" json:"reason" validate:"required"`
+	Items        []ReturnItem `gorm:"foreignKey:ReturnRequestID" json:"items" validate:"required,dive"`
+	RefundAmount Money        `gorm:"embedded;embeddedPrefix:refund_amount_" json:"refund_amount"`
+	Restocked    bool         `gorm:"not null;default:false" json:"restocked"`
+	CreatedAt    time.Time    `gorm:"not null;autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time    `gorm:"not null;autoUpdateTime" json:"updated_at"`
+}
+
+type ReturnItem struct {
+	ID              uint  `gorm:"primaryKey" json:"id"`
+	ReturnRequestID uint  `gorm:"not null;index" json:"return_request_id"`
+	ProductID       uint  `gorm:"not null" json:"product_id" validate:"required"`
+	Quantity        int   `gorm:"not null" json:"quantity" validate:"required,gt=0"`
+	RefundAmount    Money `gorm:"embedded;embeddedPrefix:refund_amount_" json:"refund_amount"`
+}
+
+// RefundFor works out what the customer paid for quantity units of an order
+// line: the discounted line price plus any tax added on top of it.
+func RefundFor(order *Order, item *OrderItem, quantity int) Money {
+	paid := item.LineTotal.Sub(item.Discount)
+	for _, line := range order.TaxLines {
+		if line.ProductID == item.ProductID && !line.Inclusive {
+			paid = paid.Add(line.Amount)
+		}
+	}
+	return paid.Scale(int64(quantity), int64(item.Quantity))
+}
+
+type ReturnDecision struct {
+	Actor  string `json:"actor" validate:"required"`
+	Reason string `json:"reason"`
+}
+
+type ReturnReceipt struct {
+	Actor   string `json:"actor" validate:"required"`
+	Restock bool   `json:"restock"`
+	Reason  string `json:"reason"`
+}
+
+type ReturnStatusHistory struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	ReturnRequestID uint      `gorm:"not null;index" json:"return_request_id"`
+	FromStatus      string    `json:"from_status"`
+	ToStatus        string    `gorm:"not null" json:"to_status"`
+	Actor           string    `gorm:"not null" json:"actor"`
+	Reason          string    `json:"reason"`
+	CreatedAt       time.Time `gorm:"not null;autoCreateTime" json:"created_at"`
+}
+
+func (ReturnStatusHistory) TableName() string {
+	return "return_status_history"
+}
+
+// ReturnError is returned when a return cannot be opened for the requested
+// order or items.
+type ReturnError struct {
+	Reason string
+}
+
+func (e *ReturnError) Error() string {
+	return e.Reason
+}
+
+// InvalidReturnTransitionError is returned when a status change is not
+// allowed by the return workflow.
+type InvalidReturnTransitionError struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (e *InvalidReturnTransitionError) Error() string {
+	return "cannot move return from '" + e.From + "' to '" + e.To + "'"
+}
+
+var ReturnBaseMessages = map[string]string{
+	"required": "is required",
+	"gt":       "must be greater than 0",
+}
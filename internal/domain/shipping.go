@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+type ShippingMethod struct {
+	ID       uint           `gorm:"primaryKey" json:"id"`
+	Code     string         `gorm:"not null;uniqueIndex" json:"code" validate:"required"`
+	Name     string         `gorm:"not null" json:"name" validate:"required"`
+	Carrier  string         `gorm:"not null" json:"carrier" validate:"required"`
+	Disabled bool           `gorm:"not null;default:false" json:"disabled"`
+	Rates    []ShippingRate `gorm:"foreignKey:ShippingMethodID" json:"rates" validate:"required,dive"`
+}
+
+// ShippingRate is one pricing rule of a shipping method. An empty Zone
+// matches every country and zero maximums leave the range open.
+type ShippingRate struct {
+	ID               uint    `gorm:"primaryKey" json:"id"`
+	ShippingMethodID uint    `gorm:"not null;index" json:"shipping_method_id"`
+	Zone             string  `gorm:"not null;default:''" json:"zone"`
+	MinWeight        float64 `gorm:"not null;default:0" json:"min_weight" validate:"gte=0"`
+	MaxWeight        float64 `gorm:"not null;default:0" json:"max_weight" validate:"gte=0"`
+	MinOrderTotal    Money   `gorm:"embedded;embeddedPrefix:min_order_total_" json:"min_order_total"`
+	MaxOrderTotal    Money   `gorm:"embedded;embeddedPrefix:max_order_total_" json:"max_order_total"`
+	Price            Money   `gorm:"embedded;embeddedPrefix:price_" json:"price"`
+}
+
+// Matches reports whether the rate applies to a parcel of the given weight
+// and order total shipped to the country.
+func (r *ShippingRate) Matches(country string, weight float64, orderTotal Money) bool {
+	if r.Zone != "" && r.Zone != country {
+		return false
+	}
+	if weight < r.MinWeight || (r.MaxWeight > 0 && weight > r.MaxWeight) {
+		return false
+	}
+	if orderTotal.Amount < r.MinOrderTotal.Amount || (r.MaxOrderTotal.Amount > 0 && orderTotal.Amount > r.MaxOrderTotal.Amount) {
+		return false
+	}
+	return true
+}
+
+const (
+	ShipmentStatusShipped   = "shipped"
+	ShipmentStatusDelivered = "delivered"
+)
+
+type Shipment struct {
+	ID             uint           `gorm:"primaryKey" json:"id"`
+	OrderID        uint           `gorm:"not null;index" json:"order_id"`
+	Carrier        string         `gorm:"not null" json:"carrier"`
+	TrackingNumber string         `gorm:"not null" json:"tracking_number"`
+	Status         string         `gorm:"not null" json:"status"`
+	Items          []ShipmentItem `gorm:"foreignKey:ShipmentID" json:"items"`
+	ShippedAt      time.Time      `gorm:"not null" json:"shipped_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at"`
+}
+
+// ShipmentItem is the number of units of one order line packed in a
+// shipment.
+type ShipmentItem struct {
+	ID          uint `gorm:"primaryKey" json:"id"`
+	ShipmentID  uint `gorm:"not null;index" json:"shipment_id"`
+	OrderItemID uint `gorm:"not null;index" json:"order_item_id"`
+	ProductID   uint `gorm:"not null" json:"product_id" validate:"required"`
+	Quantity    int  `gorm:"not null" json:"quantity" validate:"required,gt=0"`
+}
+
+// ShipmentRequest ships the listed items, or every unit not shipped yet when
+// Items is empty.
+type ShipmentRequest struct {
+	Carrier        string         `json:"carrier" validate:"required"`
+	TrackingNumber string         `json:"tracking_number" validate:"required"`
+	Actor          string         `json:"actor" validate:"required"`
+	Items          []ShipmentItem `json:"items" validate:"omitempty,dive"`
+}
+
+type ShipmentDelivery struct {
+	Actor string `json:"actor" validate:"required"`
+}
+
+// ShippingError is returned when the chosen shipping method cannot be used
+// for an order.
+type ShippingError struct {
+	Reason string
+}
+
+func (e *ShippingError) Error() string {
+	return "shipping method " + e.Reason
+}
+
+// FulfilmentError is returned when a shipment asks for more units of a product
+// than are left to ship on the order.
+type FulfilmentError struct {
+	ProductID uint
+	Requested int
+	Remaining int
+}
+
+func (e *FulfilmentError) Error() string {
+	return fmt.Sprintf("product %d has %d unit(s) left to ship, %d requested", e.ProductID, e.Remaining, e.Requested)
+}
+
+var ShippingBaseMessages = map[string]string{
+	"required": "is required",
+	"gt":       "must be greater than 0",
+	"gte":      "must be greater than or equal to 0",
+	"iso4217":  "must be an ISO 4217 currency code",
+}
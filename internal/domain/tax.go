@@ -0,0 +1,34 @@
+package domain
+
+// TaxRate is one row of the tax table. Empty Region and Category act as
+// wildcards; the most specific matching row wins.
+type TaxRate struct {
+	ID       uint    `gorm:"primaryKey" json:"id"`
+	Country  string  `gorm:"not null;index" json:"country" validate:"required,len=2"`
+	Region   string  `gorm:"not null;default:''" json:"region"`
+	Category string  `gorm:"not null;default:''" json:"category"`
+	Name     string  `gorm:"not null" json:"name" validate:"required"`
+	Rate     float64 `gorm:"not null" json:"rate" validate:"gte=0,lte=100"`
+}
+
+// OrderTaxLine is the tax charged on one order item. Inclusive lines are
+// already part of the item price and do not add to the order total.
+type OrderTaxLine struct {
+	ID            uint    `gorm:"primaryKey" json:"id"`
+	OrderID       uint    `gorm:"not null;index" json:"order_id"`
+	ProductID     uint    `gorm:"not null" json:"product_id"`
+	Name          string  `gorm:"not null" json:"name"`
+	Country       string  `gorm:"not null" json:"country"`
+	Region        string  `json:"region"`
+	Rate          float64 `gorm:"not null" json:"rate"`
+	TaxableAmount Money   `gorm:"embedded;embeddedPrefix:taxable_amount_" json:"taxable_amount"`
+	Amount        Money   `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	Inclusive     bool    `gorm:"not null" json:"inclusive"`
+}
+
+var TaxRateBaseMessages = map[string]string{
+	"required": "is required",
+	"len":      "must be a 2-letter country code",
+	"gte":      "must be greater than or equal to 0",
+	"lte":      "must be less than or equal to 100",
+}
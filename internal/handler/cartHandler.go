@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"e-commerce/internal/service"
+	"e-commerce/internal/validation"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CartHandler struct {
+	CartRepo    *repository.CartRepository
+	OrderRepo   *repository.OrderRepository
+	UserRepo    *repository.UserRepository
+	ProductRepo *repository.ProductRepository
+	Orders      *service.OrderService
+}
+
+func NewCartHandler(cr *repository.CartRepository, or *repository.OrderRepository, ur *repository.UserRepository, pr *repository.ProductRepository, orders *service.OrderService) *CartHandler {
+	return &CartHandler{CartRepo: cr, OrderRepo: or, UserRepo: ur, ProductRepo: pr, Orders: orders}
+}
+
+func (h *CartHandler) GetCart(c *gin.Context) {
+	userID, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+
+	h.respondWithCart(c, userID)
+}
+
+func (h *CartHandler) AddItem(c *gin.Context) {
+	userID, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+
+	var item domain.CartItem
+	if err := c.BindJSON(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&item); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.CartBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	if _, err := h.ProductRepo.GetProductByID(strconv.Itoa(int(item.ProductID))); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if err := h.CartRepo.AddItem(userID, item.ProductID, item.Quantity); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error adding item to cart"})
+		return
+	}
+
+	h.respondWithCart(c, userID)
+}
+
+func (h *CartHandler) UpdateItem(c *gin.Context) {
+	userID, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var update domain.CartItemUpdate
+	if err := c.BindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&update); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.CartBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	if err := h.CartRepo.UpdateItemQuantity(userID, uint(productID), update.Quantity); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product is not in the cart"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating cart item"})
+		return
+	}
+
+	h.respondWithCart(c, userID)
+}
+
+func (h *CartHandler) RemoveItem(c *gin.Context) {
+	userID, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+
+	productID, err := strconv.ParseUint(c.Param("product_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	if err := h.CartRepo.RemoveItem(userID, uint(productID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product is not in the cart"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error removing cart item"})
+		return
+	}
+
+	h.respondWithCart(c, userID)
+}
+
+func (h *CartHandler) Checkout(c *gin.Context) {
+	var checkout domain.CartCheckout
+	if err := c.BindJSON(&checkout); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&checkout); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.CartBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	user, err := h.UserRepo.GetUserByID(strconv.Itoa(int(checkout.UserID)))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	cart, err := h.CartRepo.GetCartByUserID(checkout.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving cart"})
+		return
+	}
+
+	if len(cart.Items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+		return
+	}
+
+	order := domain.Order{
+		UserID:           checkout.UserID,
+		CouponCode:       checkout.CouponCode,
+		ShippingAddress:  checkout.ShippingAddress,
+		ShippingCountry:  checkout.ShippingCountry,
+		ShippingRegion:   checkout.ShippingRegion,
+		ShippingMethodID: checkout.ShippingMethodID,
+		Currency:         checkout.Currency,
+		Status:           domain.OrderStatusPendingPayment,
+	}
+	if order.ShippingAddress == "" {
+		order.ShippingAddress = user.Address
+	}
+	for _, item := range cart.Items {
+		order.Items = append(order.Items, domain.OrderItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+
+	if err := h.Orders.PriceOrder(&order); err != nil {
+		handleOrderPlacementError(c, err)
+		return
+	}
+
+	if err := h.OrderRepo.SaveCartOrder(&order, cart); err != nil {
+		handleOrderPlacementError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Order created successfully!", "order": order})
+}
+
+func (h *CartHandler) userFromParam(c *gin.Context) (uint, bool) {
+	userIDStr := c.Param("user_id")
+	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+
+	if _, err := h.UserRepo.GetUserByID(userIDStr); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return 0, false
+	}
+
+	return uint(userID), true
+}
+
+// respondWithCart prices the cart with the current catalog, in the currency
+// given by the currency query parameter or the base currency, and flags
+// lines that can no longer be fulfilled from stock or will be backordered.
+func (h *CartHandler) respondWithCart(c *gin.Context, userID uint) {
+	currency := strings.ToUpper(c.DefaultQuery("currency", domain.BaseCurrency))
+
+	cart, err := h.CartRepo.GetCartByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving cart"})
+		return
+	}
+
+	view := domain.CartView{UserID: userID, Items: []domain.CartLine{}}
+	subtotal := domain.NewMoney(0, currency)
+	for _, item := range cart.Items {
+		product, err := h.ProductRepo.GetProductByID(strconv.Itoa(int(item.ProductID)))
+		if err != nil {
+			view.Warnings = append(view.Warnings, fmt.Sprintf("Product with ID %d is no longer available", item.ProductID))
+			continue
+		}
+
+		price, err := h.Orders.Currency.Convert(product.Price, currency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No exchange rate to " + currency})
+			return
+		}
+
+		line := domain.CartLine{
+			ProductID: product.ID,
+			Name:      product.Name,
+			Quantity:  item.Quantity,
+			UnitPrice: price,
+			LineTotal: price.Times(item.Quantity),
+			Available: product.Quantity,
+			InStock:   product.Quantity >= item.Quantity,
+		}
+		subtotal = subtotal.Add(line.LineTotal)
+
+		switch {
+		case line.InStock:
+		case product.AcceptsBackorders(time.Now()):
+			view.Warnings = append(view.Warnings, fmt.Sprintf("%d unit(s) of %s will be backordered", item.Quantity-max(product.Quantity, 0), product.Name))
+		default:
+			view.Warnings = append(view.Warnings, fmt.Sprintf("Only %d unit(s) of %s in stock", product.Quantity, product.Name))
+		}
+		view.Items = append(view.Items, line)
+	}
+	view.Subtotal = subtotal
+
+	c.JSON(http.StatusOK, view)
+}
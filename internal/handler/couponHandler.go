@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"e-commerce/internal/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"strings"
+)
+
+type CouponHandler struct {
+	CouponRepo *repository.CouponRepository
+}
+
+func NewCouponHandler(cr *repository.CouponRepository) *CouponHandler {
+	return &CouponHandler{CouponRepo: cr}
+}
+
+func (h *CouponHandler) CreateCoupon(c *gin.Context) {
+	var coupon domain.Coupon
+	if err := c.BindJSON(&coupon); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	coupon.Code = strings.ToUpper(strings.TrimSpace(coupon.Code))
+	if !h.validateCoupon(c, &coupon) {
+		return
+	}
+
+	if _, err := h.CouponRepo.GetCouponByCode(coupon.Code); err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon code already exists"})
+		return
+	}
+
+	coupon.UsageCount = 0
+	if err := h.CouponRepo.SaveCoupon(&coupon); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving coupon"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, coupon)
+}
+
+func (h *CouponHandler) GetAllCoupons(c *gin.Context) {
+	coupons, err := h.CouponRepo.GetAllCoupons()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving coupons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupons)
+}
+
+func (h *CouponHandler) GetCouponByCode(c *gin.Context) {
+	code := strings.ToUpper(c.Param("code"))
+	coupon, err := h.CouponRepo.GetCouponByCode(code)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+func (h *CouponHandler) UpdateCoupon(c *gin.Context) {
+	code := strings.ToUpper(c.Param("code"))
+
+	var coupon domain.Coupon
+	if err := c.BindJSON(&coupon); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	coupon.Code = code
+	if !h.validateCoupon(c, &coupon) {
+		return
+	}
+
+	if _, err := h.CouponRepo.GetCouponByCode(code); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+
+	if err := h.CouponRepo.UpdateCoupon(code, &coupon); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon updated successfully!"})
+}
+
+func (h *CouponHandler) DeleteCoupon(c *gin.Context) {
+	code := strings.ToUpper(c.Param("code"))
+
+	if _, err := h.CouponRepo.GetCouponByCode(code); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+
+	if err := h.CouponRepo.DeleteCoupon(code); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting coupon"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Coupon deleted successfully!"})
+}
+
+func (h *CouponHandler) GetRedemptions(c *gin.Context) {
+	code := strings.ToUpper(c.Param("code"))
+	coupon, err := h.CouponRepo.GetCouponByCode(code)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Coupon not found"})
+		return
+	}
+
+	redemptions, err := h.CouponRepo.GetRedemptions(coupon.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving redemptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, redemptions)
+}
+
+func (h *CouponHandler) validateCoupon(c *gin.Context, coupon *domain.Coupon) bool {
+	if err := validation.ValidateStruct(coupon); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.CouponBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return false
+	}
+
+	if coupon.Type == domain.CouponTypePercentage && coupon.Value > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Value must not exceed 100 for percentage coupons"})
+		return false
+	}
+
+	if coupon.Type == domain.CouponTypeFixedAmount && coupon.Amount.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than 0 for fixed amount coupons"})
+		return false
+	}
+	defaultCurrency(&coupon.Amount, &coupon.MinOrderValue)
+
+	if coupon.StartsAt != nil && coupon.ExpiresAt != nil && !coupon.ExpiresAt.After(*coupon.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be after starts_at"})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"e-commerce/internal/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"strings"
+)
+
+type ExchangeRateHandler struct {
+	ExchangeRateRepo *repository.ExchangeRateRepository
+}
+
+func NewExchangeRateHandler(er *repository.ExchangeRateRepository) *ExchangeRateHandler {
+	return &ExchangeRateHandler{ExchangeRateRepo: er}
+}
+
+func (h *ExchangeRateHandler) SaveExchangeRate(c *gin.Context) {
+	var rate domain.ExchangeRate
+	if err := c.BindJSON(&rate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	rate.From = strings.ToUpper(rate.From)
+	rate.To = strings.ToUpper(rate.To)
+	if err := validation.ValidateStruct(&rate); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.ExchangeRateBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	if rate.From == rate.To {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be different currencies"})
+		return
+	}
+
+	if err := h.ExchangeRateRepo.SaveExchangeRate(&rate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving exchange rate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rate)
+}
+
+func (h *ExchangeRateHandler) GetAllExchangeRates(c *gin.Context) {
+	rates, err := h.ExchangeRateRepo.GetAllExchangeRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving exchange rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+func (h *ExchangeRateHandler) DeleteExchangeRate(c *gin.Context) {
+	if err := h.ExchangeRateRepo.DeleteExchangeRate(c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting exchange rate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange rate deleted successfully!"})
+}
+
+// defaultCurrency fills in the base currency for amounts sent without one.
+func defaultCurrency(amounts ...*domain.Money) {
+	for _, amount := range amounts {
+		if amount.Currency == "" {
+			amount.Currency = domain.BaseCurrency
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/service"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+type ExportHandler struct {
+	Exporter *service.Exporter
+}
+
+func NewExportHandler(exporter *service.Exporter) *ExportHandler {
+	return &ExportHandler{Exporter: exporter}
+}
+
+// ExportOrders streams order items with the filters of the order list.
+// Paging parameters are ignored.
+func (h *ExportHandler) ExportOrders(c *gin.Context) {
+	query := c.Request.URL.Query()
+	filter, err := ParseOrderFilter(query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.After = nil
+
+	format, ok := startExport(c, query, "orders")
+	if !ok {
+		return
+	}
+	if err := h.Exporter.ExportOrders(c.Writer, format, filter); err != nil {
+		handleExportError(c, "orders", err)
+	}
+}
+
+func (h *ExportHandler) ExportPayments(c *gin.Context) {
+	query := c.Request.URL.Query()
+	filter, err := ParsePaymentFilter(query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format, ok := startExport(c, query, "payments")
+	if !ok {
+		return
+	}
+	if err := h.Exporter.ExportPayments(c.Writer, format, filter); err != nil {
+		handleExportError(c, "payments", err)
+	}
+}
+
+func (h *ExportHandler) ExportUsers(c *gin.Context) {
+	query := c.Request.URL.Query()
+	format, ok := startExport(c, query, "users")
+	if !ok {
+		return
+	}
+	if err := h.Exporter.ExportUsers(c.Writer, format, ParseUserFilter(query)); err != nil {
+		handleExportError(c, "users", err)
+	}
+}
+
+// ParsePaymentFilter builds a payment export filter from the user_id,
+// order_id, from and to query parameters.
+func ParsePaymentFilter(query url.Values) (*domain.PaymentFilter, error) {
+	filter := &domain.PaymentFilter{}
+
+	var err error
+	if filter.UserID, err = parseUintParam(query, "user_id"); err != nil {
+		return nil, err
+	}
+	if filter.OrderID, err = parseUintParam(query, "order_id"); err != nil {
+		return nil, err
+	}
+	if filter.From, err = parseDateParam(query, "from", false); err != nil {
+		return nil, err
+	}
+	if filter.To, err = parseDateParam(query, "to", true); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// ParseUserFilter builds a user export filter from the name, email and role
+// query parameters.
+func ParseUserFilter(query url.Values) *domain.UserFilter {
+	return &domain.UserFilter{Name: query.Get("name"), Email: query.Get("email"), Role: query.Get("role")}
+}
+
+// ParseExportFormat reads the format query parameter, csv by default.
+func ParseExportFormat(query url.Values) (string, error) {
+	format := query.Get("format")
+	switch format {
+	case "":
+		return domain.ExportFormatCSV, nil
+	case domain.ExportFormatCSV, domain.ExportFormatNDJSON:
+		return format, nil
+	default:
+		return "", domain.ErrExportFormat
+	}
+}
+
+// startExport sets the response headers for a download of the named export.
+func startExport(c *gin.Context, query url.Values, name string) (string, bool) {
+	format, err := ParseExportFormat(query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == domain.ExportFormatNDJSON {
+		contentType = "application/x-ndjson"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+name+"."+format+`"`)
+	c.Status(http.StatusOK)
+	return format, true
+}
+
+// handleExportError reports a failed export. Once rows have been sent the
+// status can no longer change, so the connection is aborted instead, which
+// keeps clients from mistaking a truncated file for a complete one.
+func handleExportError(c *gin.Context, name string, err error) {
+	log.Printf("Failed to export %s: %v\n", name, err)
+	if c.Writer.Written() {
+		panic(http.ErrAbortHandler)
+	}
+
+	c.Header("Content-Disposition", "")
+	c.Header("Content-Type", "")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error exporting " + name})
+}
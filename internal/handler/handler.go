@@ -2,32 +2,62 @@ package handler
 
 import (
 	"e-commerce/internal/repository"
+	"e-commerce/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go"
+	"log"
+	"net/http"
 	"os"
+	"runtime/debug"
+	"time"
 )
 
 type Handler struct {
-	order   *OrderHandler
-	user    *UserHandler
-	product *ProductHandler
-	payment *PaymentHandler
+	order    *OrderHandler
+	user     *UserHandler
+	product  *ProductHandler
+	payment  *PaymentHandler
+	cart     *CartHandler
+	coupon   *CouponHandler
+	taxRate  *TaxRateHandler
+	exchange *ExchangeRateHandler
+	shipping *ShippingHandler
+	shipment *ShipmentHandler
+	returns  *ReturnHandler
+	export   *ExportHandler
+	health   *HealthHandler
+
+	idempotency gin.HandlerFunc
 }
 
-func NewHandler(order *repository.OrderRepository, payment *repository.PaymentRepository, user *repository.UserRepository, product *repository.ProductRepository) *Handler {
+func NewHandler(order *repository.OrderRepository, payment *repository.PaymentRepository, user *repository.UserRepository, product *repository.ProductRepository, cart *repository.CartRepository, coupon *repository.CouponRepository, taxRate *repository.TaxRateRepository, exchangeRate *repository.ExchangeRateRepository, shipping *repository.ShippingRepository, shipment *repository.ShipmentRepository, returns *repository.ReturnRepository, idempotency *repository.IdempotencyRepository, idempotencyTTL time.Duration, orders *service.OrderService, gateway service.PaymentGateway, signer *service.HomebankSigner) *Handler {
 	return &Handler{
-		order:   NewOrderHandler(order, user, product),
-		user:    NewUserHandler(user),
-		product: NewProductHandler(product),
-		payment: NewPaymentHandler(payment),
+		order:    NewOrderHandler(order, user, payment, orders, gateway),
+		user:     NewUserHandler(user),
+		product:  NewProductHandler(product),
+		payment:  NewPaymentHandler(payment, order, user, gateway, signer),
+		cart:     NewCartHandler(cart, order, user, product, orders),
+		coupon:   NewCouponHandler(coupon),
+		taxRate:  NewTaxRateHandler(taxRate),
+		exchange: NewExchangeRateHandler(exchangeRate),
+		shipping: NewShippingHandler(shipping),
+		shipment: NewShipmentHandler(shipment),
+		returns:  NewReturnHandler(returns, payment, gateway),
+		export:   NewExportHandler(service.NewExporter(order, payment, user)),
+		health:   NewHealthHandler(gateway),
+
+		idempotency: Idempotency(idempotency, idempotencyTTL),
 	}
 }
 
 func (h *Handler) InitRoutes() *gin.Engine {
 	router := gin.New()
+	router.Use(gin.CustomRecoveryWithWriter(nil, recoverPanic))
 
 	stripe.Key = os.Getenv("STRIPE_KEY")
 
+	router.GET("/readyz", h.health.Ready)
+
 	user := router.Group("/user")
 	{
 		user.GET("/", h.user.GetAllUsers)
@@ -53,10 +83,15 @@ func (h *Handler) InitRoutes() *gin.Engine {
 	order := router.Group("/orders")
 	{
 		order.GET("/", h.order.GetAllOrders)
-		order.POST("/", h.order.CreateOrder)
+		order.POST("/", h.idempotency, h.order.CreateOrder)
 		order.PUT("/:id", h.order.UpdateOrder)
 		order.DELETE("/:id", h.order.DeleteOrder)
 		order.GET("/:id", h.order.GetOrderByID)
+		order.POST("/:id/transitions", h.order.TransitionOrder)
+		order.POST("/:id/cancel", h.order.CancelOrder)
+		order.GET("/:id/history", h.order.GetOrderHistory)
+		order.POST("/:id/shipments", h.shipment.CreateShipment)
+		order.GET("/:id/shipments", h.shipment.GetOrderShipments)
 		order.GET("/search", h.order.SearchOrdersByStatus)
 		order.GET("/search/:user", h.order.SearchOrdersByUserID)
 	}
@@ -64,14 +99,98 @@ func (h *Handler) InitRoutes() *gin.Engine {
 	payment := router.Group("/payments")
 	{
 		payment.GET("/", h.payment.GetAllPayments)
-		payment.POST("/", h.payment.CreatePayment)
+		payment.POST("/", h.idempotency, h.payment.CreatePayment)
 		payment.PUT("/:id", h.payment.UpdatePayment)
 		payment.DELETE("/:id", h.payment.DeletePayment)
 		payment.GET("/:id", h.payment.GetPaymentByID)
+		payment.POST("/:id/capture", h.payment.CapturePayment)
+		payment.GET("/:id/events", h.payment.GetPaymentEvents)
+		payment.POST("/:id/refunds", h.idempotency, h.payment.RefundPayment)
+		payment.GET("/:id/refunds", h.payment.GetRefunds)
+		payment.GET("/card-encryption", h.payment.GetCardEncryption)
+		payment.POST("/callbacks/homebank", h.payment.HomebankCallback)
+		payment.POST("/callbacks/homebank/failure", h.payment.HomebankFailureCallback)
 		payment.GET("/search/user/:user_id", h.payment.SearchPaymentsByUserID)
 		payment.GET("/search/:order_id", h.payment.SearchPaymentsByOrderID)
 		payment.GET("/search", h.payment.SearchPaymentsByStatus)
 	}
 
+	cart := router.Group("/cart")
+	{
+		cart.POST("/checkout", h.idempotency, h.cart.Checkout)
+		cart.GET("/:user_id", h.cart.GetCart)
+		cart.POST("/:user_id/items", h.cart.AddItem)
+		cart.PUT("/:user_id/items/:product_id", h.cart.UpdateItem)
+		cart.DELETE("/:user_id/items/:product_id", h.cart.RemoveItem)
+	}
+
+	coupon := router.Group("/coupons")
+	{
+		coupon.GET("/", h.coupon.GetAllCoupons)
+		coupon.POST("/", h.coupon.CreateCoupon)
+		coupon.GET("/:code", h.coupon.GetCouponByCode)
+		coupon.PUT("/:code", h.coupon.UpdateCoupon)
+		coupon.DELETE("/:code", h.coupon.DeleteCoupon)
+		coupon.GET("/:code/redemptions", h.coupon.GetRedemptions)
+	}
+
+	taxRate := router.Group("/tax-rates")
+	{
+		taxRate.GET("/", h.taxRate.GetAllTaxRates)
+		taxRate.POST("/", h.taxRate.CreateTaxRate)
+		taxRate.DELETE("/:id", h.taxRate.DeleteTaxRate)
+	}
+
+	exchangeRate := router.Group("/exchange-rates")
+	{
+		exchangeRate.GET("/", h.exchange.GetAllExchangeRates)
+		exchangeRate.POST("/", h.exchange.SaveExchangeRate)
+		exchangeRate.DELETE("/:id", h.exchange.DeleteExchangeRate)
+	}
+
+	shipping := router.Group("/shipping-methods")
+	{
+		shipping.GET("/", h.shipping.GetAllShippingMethods)
+		shipping.POST("/", h.shipping.CreateShippingMethod)
+		shipping.GET("/:id", h.shipping.GetShippingMethodByID)
+		shipping.DELETE("/:id", h.shipping.DeleteShippingMethod)
+	}
+
+	shipment := router.Group("/shipments")
+	{
+		shipment.GET("/:id", h.shipment.GetShipmentByID)
+		shipment.POST("/:id/deliver", h.shipment.DeliverShipment)
+	}
+
+	returns := router.Group("/returns")
+	{
+		returns.GET("/", h.returns.GetAllReturns)
+		returns.POST("/", h.returns.CreateReturn)
+		returns.GET("/:id", h.returns.GetReturnByID)
+		returns.POST("/:id/approve", h.returns.ApproveReturn)
+		returns.POST("/:id/reject", h.returns.RejectReturn)
+		returns.POST("/:id/receive", h.returns.ReceiveReturn)
+		returns.POST("/:id/refund", h.returns.RefundReturn)
+		returns.GET("/:id/history", h.returns.GetReturnHistory)
+	}
+
+	export := router.Group("/exports")
+	{
+		export.GET("/orders", h.export.ExportOrders)
+		export.GET("/payments", h.export.ExportPayments)
+		export.GET("/users", h.export.ExportUsers)
+	}
+
 	return router
 }
+
+// recoverPanic answers a panicking request with a 500. http.ErrAbortHandler
+// is panicked again so net/http closes the connection, which is how a
+// handler that has already sent part of its response reports a failure.
+func recoverPanic(c *gin.Context, err any) {
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	log.Printf("Recovered from panic: %v\n%s", err, debug.Stack())
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"e-commerce/internal/service"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type HealthHandler struct {
+	Gateway service.PaymentGateway
+}
+
+func NewHealthHandler(gateway service.PaymentGateway) *HealthHandler {
+	return &HealthHandler{Gateway: gateway}
+}
+
+// Ready reports whether the service can take payments.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	if checker, ok := h.Gateway.(service.ReadinessChecker); ok {
+		if err := checker.Ready(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "Payment gateway not ready: " + err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"e-commerce/internal/repository"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	idempotencySideEffectsKey = "idempotency.side_effects"
+)
+
+// markSideEffects tells the Idempotency middleware that the request has
+// changed state that a failure will not roll back, such as a pending payment
+// or a call to the payment gateway. Server errors after that point are
+// stored and replayed like any other response, so that a retry cannot repeat
+// the side effect.
+func markSideEffects(c *gin.Context) {
+	c.Set(idempotencySideEffectsKey, true)
+}
+
+// responseRecorder keeps a copy of everything written to the client so that
+// it can be stored with the idempotency key.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency replays the stored response when a request is retried with the
+// same Idempotency-Key header. Reusing a key for a different request, or while
+// the first request is still running, is rejected with 409. Requests without
+// the header are passed through unchanged. Server errors, including panics,
+// release the key for a retry unless the handler marked side effects.
+func Idempotency(repo *repository.IdempotencyRepository, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Error reading request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		record, started, err := repo.BeginRequest(key, fingerprint, ttl)
+		if err != nil {
+			log.Printf("Failed to claim idempotency key %q: %v\n", key, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error checking idempotency key"})
+			return
+		}
+
+		if !started {
+			switch {
+			case record.Fingerprint != fingerprint:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case !record.Completed:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(record.StatusCode, record.ContentType, record.ResponseBody)
+				c.Abort()
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		defer func() {
+			if r := recover(); r != nil {
+				finishIdempotentRequest(c, repo, key, http.StatusInternalServerError, gin.MIMEJSON, []byte(`{"error":"Internal server error"}`))
+				panic(r)
+			}
+		}()
+		c.Next()
+
+		finishIdempotentRequest(c, repo, key, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+	}
+}
+
+// finishIdempotentRequest stores the response with the key, or releases the
+// key when the request failed before it had any side effects.
+func finishIdempotentRequest(c *gin.Context, repo *repository.IdempotencyRepository, key string, status int, contentType string, body []byte) {
+	var err error
+	if status >= http.StatusInternalServerError && !c.GetBool(idempotencySideEffectsKey) {
+		err = repo.ReleaseRequest(key)
+	} else {
+		err = repo.CompleteRequest(key, status, contentType, body)
+	}
+	if err != nil {
+		log.Printf("Failed to store idempotency key %q: %v\n", key, err)
+	}
+}
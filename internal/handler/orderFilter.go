@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"e-commerce/internal/domain"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOrderPageSize = 50
+	maxOrderPageSize     = 200
+)
+
+// ParseOrderFilter builds an order filter from list query parameters:
+// user_id, status (repeated or comma separated), from, to, min_total,
+// max_total, product_id, sort, limit and cursor. Total bounds and sorting use
+// the base currency equivalent of the order total.
+func ParseOrderFilter(query url.Values) (*domain.OrderFilter, error) {
+	filter := &domain.OrderFilter{SortBy: domain.OrderSortDate, Descending: true, Limit: defaultOrderPageSize}
+
+	var err error
+	if filter.UserID, err = parseUintParam(query, "user_id"); err != nil {
+		return nil, err
+	}
+	if filter.ProductID, err = parseUintParam(query, "product_id"); err != nil {
+		return nil, err
+	}
+	if filter.MinTotal, err = parseMoneyParam(query, "min_total"); err != nil {
+		return nil, err
+	}
+	if filter.MaxTotal, err = parseMoneyParam(query, "max_total"); err != nil {
+		return nil, err
+	}
+	if filter.From, err = parseDateParam(query, "from", false); err != nil {
+		return nil, err
+	}
+	if filter.To, err = parseDateParam(query, "to", true); err != nil {
+		return nil, err
+	}
+
+	for _, value := range query["status"] {
+		for _, status := range strings.Split(value, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				filter.Statuses = append(filter.Statuses, status)
+			}
+		}
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		filter.Descending = strings.HasPrefix(sort, "-")
+		filter.SortBy = strings.TrimPrefix(sort, "-")
+		switch filter.SortBy {
+		case domain.OrderSortDate, domain.OrderSortTotal, domain.OrderSortID:
+		default:
+			return nil, errors.New("sort must be one of 'order_date', 'total_price' or 'id', optionally prefixed with '-'")
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit <= 0 {
+			return nil, errors.New("limit must be a positive number")
+		}
+		if filter.Limit > maxOrderPageSize {
+			filter.Limit = maxOrderPageSize
+		}
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		if filter.After, err = decodeOrderCursor(cursor); err != nil {
+			return nil, err
+		}
+		if filter.After.SortBy != filter.SortBy || filter.After.Descending != filter.Descending {
+			return nil, errors.New("cursor does not match the requested sort")
+		}
+	}
+
+	return filter, nil
+}
+
+// EncodeOrderCursor returns the cursor for the page that follows order.
+func EncodeOrderCursor(filter *domain.OrderFilter, order *domain.Order) string {
+	cursor := domain.OrderCursor{SortBy: filter.SortBy, Descending: filter.Descending, ID: order.ID}
+	switch filter.SortBy {
+	case domain.OrderSortDate:
+		cursor.OrderDate = order.OrderDate
+	case domain.OrderSortTotal:
+		cursor.BaseTotal = order.BaseTotal.Amount
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeOrderCursor(value string) (*domain.OrderCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("cursor is invalid")
+	}
+
+	var cursor domain.OrderCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("cursor is invalid")
+	}
+	return &cursor, nil
+}
+
+func parseUintParam(query url.Values, name string) (*uint, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil, errors.New(name + " must be a positive number")
+	}
+	result := uint(parsed)
+	return &result, nil
+}
+
+// parseMoneyParam reads an amount in major units of the base currency.
+func parseMoneyParam(query url.Values, name string) (*domain.Money, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := domain.ParseMoney(value, domain.BaseCurrency)
+	if err != nil {
+		return nil, errors.New(name + " must be an amount in " + domain.BaseCurrency)
+	}
+	return &parsed, nil
+}
+
+// parseDateParam accepts RFC 3339 timestamps and plain dates. A plain date
+// used as an upper bound covers the whole day.
+func parseDateParam(query url.Values, name string, endOfDay bool) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return &parsed, nil
+	}
+
+	parsed, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, errors.New(name + " must be a date (2006-01-02) or an RFC 3339 timestamp")
+	}
+	if endOfDay {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	return &parsed, nil
+}
@@ -1,11 +1,17 @@
 package handler
 
 import (
+	"context"
 	"e-commerce/internal/domain"
 	"e-commerce/internal/repository"
+	"e-commerce/internal/service"
 	"e-commerce/internal/validation"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+	"log"
 	"net/http"
 	"strconv"
 )
@@ -13,11 +19,13 @@ import (
 type OrderHandler struct {
 	OrderRepo   *repository.OrderRepository
 	UserRepo    *repository.UserRepository
-	ProductRepo *repository.ProductRepository
+	PaymentRepo *repository.PaymentRepository
+	Orders      *service.OrderService
+	Gateway     service.PaymentGateway
 }
 
-func NewOrderHandler(or *repository.OrderRepository, ur *repository.UserRepository, pr *repository.ProductRepository) *OrderHandler {
-	return &OrderHandler{OrderRepo: or, UserRepo: ur, ProductRepo: pr}
+func NewOrderHandler(or *repository.OrderRepository, ur *repository.UserRepository, payr *repository.PaymentRepository, orders *service.OrderService, gateway service.PaymentGateway) *OrderHandler {
+	return &OrderHandler{OrderRepo: or, UserRepo: ur, PaymentRepo: payr, Orders: orders, Gateway: gateway}
 }
 
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
@@ -34,24 +42,36 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	}
 
 	userIDStr := strconv.Itoa(int(order.UserID))
-	if _, err := h.UserRepo.GetUserByID(userIDStr); err != nil {
+	user, err := h.UserRepo.GetUserByID(userIDStr)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	for _, productID := range order.ProductIDs {
-		if _, err := h.ProductRepo.GetProductByID(strconv.Itoa(int(productID))); err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Product with ID " + strconv.Itoa(int(productID)) + " not found"})
-			return
-		}
+	if order.ShippingAddress == "" {
+		order.ShippingAddress = user.Address
+	}
+
+	order.NormalizeItems()
+	order.Status = domain.OrderStatusPendingPayment
+
+	clientTotal := order.TotalPrice
+	if err := h.Orders.PriceOrder(&order); err != nil {
+		handleOrderPlacementError(c, err)
+		return
+	}
+
+	if !clientTotal.IsZero() && (clientTotal.Amount != order.TotalPrice.Amount || (clientTotal.Currency != "" && clientTotal.Currency != order.Currency)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Total price does not match current catalog prices", "expected_total": order.TotalPrice})
+		return
 	}
 
 	if err := h.OrderRepo.SaveOrder(&order); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving order"})
+		handleOrderPlacementError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "Order created successfully!"})
+	c.JSON(http.StatusCreated, gin.H{"message": "Order created successfully!", "order": order})
 }
 
 func (h *OrderHandler) GetOrderByID(c *gin.Context) {
@@ -71,19 +91,31 @@ func (h *OrderHandler) GetOrderByID(c *gin.Context) {
 	c.JSON(http.StatusOK, order)
 }
 
+// GetAllOrders lists orders matching the query filters one page at a time.
+// next_cursor is empty on the last page.
 func (h *OrderHandler) GetAllOrders(c *gin.Context) {
-	orders, err := h.OrderRepo.GetAllOrders()
+	filter, err := ParseOrderFilter(c.Request.URL.Query())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving orders", "details": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if len(orders) == 0 {
-		c.JSON(http.StatusOK, gin.H{"message": "No orders found"})
+	limit := filter.Limit
+	filter.Limit++
+	orders, err := h.OrderRepo.FindOrders(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving orders", "details": err.Error()})
 		return
 	}
+	filter.Limit = limit
 
-	c.JSON(http.StatusOK, orders)
+	nextCursor := ""
+	if len(orders) > limit {
+		orders = orders[:limit]
+		nextCursor = EncodeOrderCursor(filter, &orders[limit-1])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"orders": orders, "next_cursor": nextCursor})
 }
 
 func (h *OrderHandler) UpdateOrder(c *gin.Context) {
@@ -112,8 +144,12 @@ func (h *OrderHandler) UpdateOrder(c *gin.Context) {
 		return
 	}
 
-	updatedOrder.OrderDate = existingOrder.OrderDate
+	if updatedOrder.Status != "" && updatedOrder.Status != existingOrder.Status {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order status can only be changed through POST /orders/:id/transitions"})
+		return
+	}
 
+	// Only the shipping address can be edited; see OrderRepository.UpdateOrder.
 	if err := h.OrderRepo.UpdateOrder(uint(id), &updatedOrder); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating order"})
 		return
@@ -122,7 +158,140 @@ func (h *OrderHandler) UpdateOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Order updated successfully!"})
 }
 
-func (h *OrderHandler) DeleteOrder(c *gin.Context) {
+// managedOrderStatuses are the statuses TransitionOrder refuses to set,
+// because reaching them has side effects that only their own flows carry out.
+var managedOrderStatuses = map[string]string{
+	domain.OrderStatusPaid:               "Orders are marked paid when their payment is captured",
+	domain.OrderStatusPartiallyFulfilled: "Fulfilment statuses follow the order's shipments",
+	domain.OrderStatusShipped:            "Fulfilment statuses follow the order's shipments",
+	domain.OrderStatusDelivered:          "Fulfilment statuses follow the order's shipments",
+	domain.OrderStatusCompleted:          "Fulfilment statuses follow the order's shipments",
+	domain.OrderStatusCancelled:          "Cancel the order through POST /orders/:id/cancel",
+	domain.OrderStatusRefunded:           "Refund the order through its payments or returns",
+	domain.OrderStatusPartiallyRefunded:  "Refund the order through its payments or returns",
+}
+
+// TransitionOrder lets staff move an order between statuses that need no
+// other changes, such as starting to process a paid order.
+func (h *OrderHandler) TransitionOrder(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var transition domain.OrderTransition
+	if err := c.BindJSON(&transition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&transition); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.OrderBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	if message, ok := managedOrderStatuses[transition.Status]; ok {
+		c.JSON(http.StatusConflict, gin.H{"error": message})
+		return
+	}
+
+	order, err := h.OrderRepo.TransitionOrder(uint(id), transition.Status, transition.Actor, transition.Reason)
+	if err != nil {
+		h.handleTransitionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, order)
+}
+
+func (h *OrderHandler) CancelOrder(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var cancellation domain.OrderCancellation
+	if err := c.BindJSON(&cancellation); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&cancellation); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.OrderBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	order, err := h.OrderRepo.CancelOrder(uint(id), cancellation.Actor, cancellation.Reason)
+	if err != nil {
+		// Cancelling again is only allowed to release payments that a
+		// previous cancellation could not.
+		var transitionErr *domain.InvalidTransitionError
+		if !errors.As(err, &transitionErr) || transitionErr.From != domain.OrderStatusCancelled {
+			h.handleTransitionError(c, err)
+			return
+		}
+		held, heldErr := h.heldPayments(uint(id))
+		if heldErr != nil || len(held) == 0 {
+			h.handleTransitionError(c, err)
+			return
+		}
+		if order, err = h.OrderRepo.GetOrderById(uint(id)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving order"})
+			return
+		}
+	}
+
+	if err := h.voidPayments(c.Request.Context(), order.ID); err != nil {
+		log.Printf("Failed to void payments for order %d: %v\n", order.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Order cancelled, but its payments could not all be released. Cancel it again to retry.", "order": order})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Order cancelled successfully!", "order": order})
+}
+
+// voidPayments releases every gateway payment of a cancelled order. An
+// authorization is voided and a charged payment is refunded.
+func (h *OrderHandler) voidPayments(ctx context.Context, orderID uint) error {
+	payments, err := h.heldPayments(orderID)
+	if err != nil {
+		return err
+	}
+
+	for i := range payments {
+		payment := &payments[i]
+		if err := releasePayment(ctx, h.PaymentRepo, h.Gateway, payment, "order cancelled"); err != nil {
+			return fmt.Errorf("payment %d: %v", payment.ID, err)
+		}
+	}
+	return nil
+}
+
+// heldPayments returns the order's payments that may still hold money on
+// the card.
+func (h *OrderHandler) heldPayments(orderID uint) ([]domain.Payment, error) {
+	payments, err := h.PaymentRepo.SearchPaymentsByOrderID(strconv.Itoa(int(orderID)))
+	if err != nil {
+		return nil, err
+	}
+
+	held := payments[:0]
+	for _, payment := range payments {
+		switch payment.PaymentStatus {
+		case domain.PaymentStatusPending, domain.PaymentStatusAuthorized, domain.PaymentStatusCaptured, domain.PaymentStatusPartiallyRefunded:
+			held = append(held, payment)
+		}
+	}
+	return held, nil
+}
+
+func (h *OrderHandler) GetOrderHistory(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
@@ -130,13 +299,76 @@ func (h *OrderHandler) DeleteOrder(c *gin.Context) {
 		return
 	}
 
-	_, err = h.OrderRepo.GetOrderById(uint(id))
+	if _, err := h.OrderRepo.GetOrderById(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	history, err := h.OrderRepo.GetOrderStatusHistory(uint(id))
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving order history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+func (h *OrderHandler) handleTransitionError(c *gin.Context, err error) {
+	var transitionErr *domain.InvalidTransitionError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
 		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error(), "from": transitionErr.From, "to": transitionErr.To})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating order status"})
+	}
+}
+
+func handleOrderPlacementError(c *gin.Context, err error) {
+	var productErr *domain.ProductNotFoundError
+	var couponErr *domain.CouponError
+	var stockErr *domain.InsufficientStockError
+	var shippingErr *domain.ShippingError
+	var rateErr *domain.ExchangeRateError
+	var cartErr *domain.CartChangedError
+	switch {
+	case errors.As(err, &productErr):
+		c.JSON(http.StatusNotFound, gin.H{"error": productErr.Error()})
+	case errors.As(err, &couponErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Coupon " + couponErr.Code + " " + couponErr.Reason})
+	case errors.As(err, &rateErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No exchange rate from " + rateErr.From + " to " + rateErr.To})
+	case errors.As(err, &shippingErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Shipping method " + shippingErr.Reason})
+	case errors.As(err, &stockErr):
+		c.JSON(http.StatusConflict, gin.H{"error": "Insufficient stock", "items": stockErr.Items})
+	case errors.As(err, &cartErr):
+		c.JSON(http.StatusConflict, gin.H{"error": "Cart changed during checkout, review it and check out again"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving order"})
+	}
+}
+
+func (h *OrderHandler) DeleteOrder(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
 		return
 	}
 
-	if err := h.OrderRepo.DeleteOrder(uint(id)); err != nil {
+	err = h.OrderRepo.DeleteOrder(uint(id))
+	var deleteErr *domain.OrderDeleteError
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	case errors.As(err, &deleteErr):
+		c.JSON(http.StatusConflict, gin.H{"error": deleteErr.Error()})
+		return
+	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting order"})
 		return
 	}
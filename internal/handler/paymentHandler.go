@@ -1,28 +1,36 @@
 package handler
 
 import (
+	"context"
 	"e-commerce/internal/domain"
 	"e-commerce/internal/repository"
 	"e-commerce/internal/service"
+	"e-commerce/internal/validation"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
 	"log"
 	"net/http"
 	"strconv"
-)
-
-const (
-	DefaultPaymentData = `{
- "hpan":"4405639704015096","expDate":"0125","cvc":"815","terminalId":"67e34d63-102f-4bd1-898e-370781d0074d"
-}`
+	"strings"
 )
 
 type PaymentHandler struct {
-	repo *repository.PaymentRepository
+	repo      *repository.PaymentRepository
+	OrderRepo *repository.OrderRepository
+	UserRepo  *repository.UserRepository
+	Gateway   service.PaymentGateway
+	Signer    *service.HomebankSigner
 }
 
-func NewPaymentHandler(repository *repository.PaymentRepository) *PaymentHandler {
+func NewPaymentHandler(repository *repository.PaymentRepository, or *repository.OrderRepository, ur *repository.UserRepository, gateway service.PaymentGateway, signer *service.HomebankSigner) *PaymentHandler {
 	return &PaymentHandler{
-		repo: repository,
+		repo:      repository,
+		OrderRepo: or,
+		UserRepo:  ur,
+		Gateway:   gateway,
+		Signer:    signer,
 	}
 }
 
@@ -35,75 +43,439 @@ func (h *PaymentHandler) GetAllPayments(c *gin.Context) {
 	c.JSON(http.StatusOK, payments)
 }
 
+// CreatePayment charges the order's outstanding balance to the card in the
+// request. The card data is passed to the gateway as is and never stored or
+// logged.
 func (h *PaymentHandler) CreatePayment(c *gin.Context) {
-	var payment domain.Payment
-	if err := c.ShouldBindJSON(&payment); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var create domain.PaymentCreate
+	if err := c.ShouldBindJSON(&create); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&create); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.PaymentBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
 		return
 	}
 
-	token, err := service.GetPaymentToken()
+	if (create.Cryptogram == "") == (create.CardToken == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either a cryptogram or a card_token"})
+		return
+	}
+	payment := domain.Payment{UserID: create.UserID, OrderID: create.OrderID, Amount: create.Amount}
+
+	order, err := h.OrderRepo.GetOrderById(payment.OrderID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 		return
 	}
 
-	encryptedData, err := service.EncryptData(DefaultPaymentData)
+	if payment.UserID != 0 && payment.UserID != order.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Order does not belong to the user"})
+		return
+	}
+
+	user, err := h.UserRepo.GetUserByID(strconv.Itoa(int(order.UserID)))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Encryption failed"})
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	payment.Amount.Currency = strings.ToUpper(payment.Amount.Currency)
+	err = h.repo.CreateOrderPayment(&payment)
+	var transitionErr *domain.InvalidTransitionError
+	var amountErr *domain.PaymentAmountError
+	switch {
+	case err == nil:
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order in status '" + transitionErr.From + "' cannot be paid"})
+		return
+	case errors.As(err, &amountErr) && amountErr.Outstanding.Amount <= 0:
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has already been paid"})
+		return
+	case errors.As(err, &amountErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Payment amount must equal the outstanding balance of " + amountErr.Outstanding.String(), "outstanding": amountErr.Outstanding})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	paymentResponse, err := service.MakePayment(token, encryptedData)
+	markSideEffects(c)
+
+	request := service.NewPaymentRequest(order, user, &payment, &create.PaymentCard)
+	result, err := h.Gateway.Authorize(c.Request.Context(), request)
 	if err != nil {
-		log.Printf("Failed to make payment: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to make payment"})
+		log.Printf("Failed to make payment %d: %v\n", payment.ID, err)
+
+		var gatewayErr *domain.PaymentGatewayError
+		if errors.As(err, &gatewayErr) {
+			if err := h.repo.TransitionPayment(&payment, domain.PaymentStatusFailed, err.Error()); err != nil {
+				log.Printf("Failed to mark payment %d failed: %v\n", payment.ID, err)
+			}
+			var keyErr *service.CardKeyChangedError
+			if errors.As(err, &keyErr) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Card encryption key has changed, encrypt the card again with the current key", "payment": payment})
+				return
+			}
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": gatewayErr.Error(), "payment": payment})
+			return
+		}
+		// The card may have been charged before the error, so the payment
+		// stays pending until the reconciler asks the gateway about it.
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Payment outcome is not known yet", "payment": payment})
 		return
 	}
 
-	payment.PaymentStatus = paymentResponse.Status
+	payment.TransactionID = result.TransactionID
+	if result.Status == domain.PaymentStatusPending {
+		c.JSON(http.StatusAccepted, payment)
+		return
+	}
+	if err := h.repo.TransitionPayment(&payment, result.Status, result.Message); err != nil {
+		var transitionErr *domain.InvalidPaymentTransitionError
+		if errors.As(err, &transitionErr) && transitionErr.From == domain.PaymentStatusFailed {
+			// The order was cancelled while the card was being charged.
+			h.releaseLateResult(context.WithoutCancel(c.Request.Context()), &payment, result)
+			c.JSON(http.StatusConflict, gin.H{"error": "Order was cancelled while the payment was being made"})
+			return
+		}
+		log.Printf("Failed to record status of payment %d: %v\n", payment.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving payment status"})
+		return
+	}
 
-	if err := h.repo.CreatePayment(&payment); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if payment.PaymentStatus == domain.PaymentStatusFailed {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Payment failed: " + result.Message, "payment": payment})
 		return
 	}
 	c.JSON(http.StatusCreated, payment)
 }
 
-func (h *PaymentHandler) GetPaymentByID(c *gin.Context) {
-	id := c.Param("id")
-	payment, err := h.repo.GetPaymentByID(id)
+// releaseLateResult gives back an authorization or charge that the gateway
+// reported for a payment that had already been failed.
+func (h *PaymentHandler) releaseLateResult(ctx context.Context, payment *domain.Payment, result *service.PaymentResult) {
+	var err error
+	switch result.Status {
+	case domain.PaymentStatusAuthorized:
+		err = h.Gateway.Void(ctx, result.TransactionID)
+	case domain.PaymentStatusCaptured:
+		err = h.Gateway.Refund(ctx, result.TransactionID, payment.Amount)
+	}
+	if err != nil {
+		log.Printf("Failed to release late %s result of payment %d: %v\n", result.Status, payment.ID, err)
+	}
+}
+
+// GetCardEncryption returns the terminal and public key clients encrypt card
+// data with before sending it as a cryptogram. Clients fetch it again when a
+// payment is rejected because the key has changed.
+func (h *PaymentHandler) GetCardEncryption(c *gin.Context) {
+	encryption, ok := h.Gateway.(service.CardEncryption)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Card encryption is not used by the payment gateway"})
+		return
+	}
+
+	key, err := encryption.CardEncryptionKey()
+	if err != nil {
+		log.Printf("Failed to load card encryption key: %v\n", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Card encryption key is not available"})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// CapturePayment charges an authorized payment and marks its order paid.
+func (h *PaymentHandler) CapturePayment(c *gin.Context) {
+	payment, ok := h.paymentFromParam(c)
+	if !ok {
+		return
+	}
+
+	if !domain.CanTransitionPayment(payment.PaymentStatus, domain.PaymentStatusCaptured) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment in status '" + payment.PaymentStatus + "' cannot be captured"})
+		return
+	}
+
+	if err := h.Gateway.Capture(c.Request.Context(), payment.TransactionID, payment.Amount); err != nil {
+		log.Printf("Failed to capture payment %d: %v\n", payment.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Error capturing payment"})
+		return
+	}
+
+	if err := h.repo.TransitionPayment(payment, domain.PaymentStatusCaptured, "captured"); err != nil {
+		handlePaymentTransitionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment captured successfully!", "payment": payment})
+}
+
+// HomebankCallback applies a payment result posted by epay to postLink.
+func (h *PaymentHandler) HomebankCallback(c *gin.Context) {
+	h.handleHomebankCallback(c, false)
+}
+
+// HomebankFailureCallback applies a payment result posted by epay to
+// failurePostLink.
+func (h *PaymentHandler) HomebankFailureCallback(c *gin.Context) {
+	h.handleHomebankCallback(c, true)
+}
+
+// handleHomebankCallback verifies the callback's secret hash and moves the
+// matching payment to captured or failed. Callbacks that were already
+// applied, or arrive after the payment moved on, are acknowledged without
+// changes so that epay stops retrying them.
+func (h *PaymentHandler) handleHomebankCallback(c *gin.Context, failed bool) {
+	var callback service.HomebankCallback
+	if err := c.ShouldBindJSON(&callback); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if !h.Signer.Verify(callback.InvoiceID, callback.SecretHash) {
+		log.Printf("Rejected Homebank callback for invoice %q: invalid secret hash\n", callback.InvoiceID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid callback signature"})
+		return
+	}
+
+	payment, err := h.repo.GetPaymentByInvoiceID(callback.InvoiceID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
 		return
 	}
-	c.JSON(http.StatusOK, payment)
+
+	status, reason := domain.PaymentStatusCaptured, "confirmed by Homebank"
+	if failed || !callback.Succeeded() {
+		status, reason = domain.PaymentStatusFailed, strings.TrimSpace(callback.Reason+" "+callback.ReasonCode.String())
+	} else if amount, err := callback.Money(); err != nil || amount != payment.Amount {
+		log.Printf("Rejected Homebank callback for payment %d: amount %s %s does not match\n", payment.ID, callback.Amount, callback.Currency)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Callback amount does not match the payment"})
+		return
+	}
+	if callback.ID != "" {
+		payment.TransactionID = callback.ID
+	}
+
+	var transitionErr *domain.InvalidPaymentTransitionError
+	err = h.repo.TransitionPayment(payment, status, reason)
+	switch {
+	case err == nil:
+	case errors.As(err, &transitionErr):
+		log.Printf("Ignored Homebank callback for payment %d: %v\n", payment.ID, err)
+	default:
+		log.Printf("Failed to apply Homebank callback for payment %d: %v\n", payment.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating payment status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Callback processed successfully!"})
 }
 
-func (h *PaymentHandler) UpdatePayment(c *gin.Context) {
-	id := c.Param("id")
-	var payment domain.Payment
-	if err := c.ShouldBindJSON(&payment); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// RefundPayment returns part or all of a captured payment to the card.
+func (h *PaymentHandler) RefundPayment(c *gin.Context) {
+	payment, ok := h.paymentFromParam(c)
+	if !ok {
+		return
+	}
+
+	var request domain.RefundRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&request); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.RefundBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	request.Amount.Currency = strings.ToUpper(request.Amount.Currency)
+	if request.Amount.Currency == "" {
+		request.Amount.Currency = payment.Amount.Currency
+	}
+
+	markSideEffects(c)
+	refund, err := refundPayment(c.Request.Context(), h.repo, h.Gateway, payment, request.Amount, request.Reason, true)
+	var limitErr *domain.RefundLimitError
+	var transitionErr *domain.InvalidPaymentTransitionError
+	switch {
+	case err == nil:
+	case errors.As(err, &limitErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund exceeds the refundable amount of " + limitErr.Refundable.String(), "refundable": limitErr.Refundable})
+		return
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment in status '" + transitionErr.From + "' cannot be refunded"})
+		return
+	case refund != nil && refund.Status == domain.RefundStatusFailed:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Error refunding payment", "refund": refund})
+		return
+	default:
+		log.Printf("Failed to refund payment %d: %v\n", payment.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error refunding payment"})
 		return
 	}
-	idUint, err := strconv.ParseUint(id, 10, 64)
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Payment refunded successfully!", "refund": refund, "payment": payment})
+}
+
+func (h *PaymentHandler) GetRefunds(c *gin.Context) {
+	payment, ok := h.paymentFromParam(c)
+	if !ok {
+		return
+	}
+
+	refunds, err := h.repo.GetRefunds(payment.ID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving refunds"})
+		return
 	}
 
-	payment.ID = uint(idUint)
+	c.JSON(http.StatusOK, refunds)
+}
 
-	if err := h.repo.UpdatePayment(&payment); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+// refundPayment reserves the refund against the payment, sends it through
+// the gateway and records the outcome. A refund the gateway rejects is kept
+// as failed and its amount can be refunded again.
+func refundPayment(ctx context.Context, repo *repository.PaymentRepository, gateway service.PaymentGateway, payment *domain.Payment, amount domain.Money, reason string, updateOrder bool) (*domain.Refund, error) {
+	refund := &domain.Refund{Amount: amount, Reason: reason}
+	if err := repo.CreateRefund(payment, refund); err != nil {
+		return nil, err
+	}
+	return refund, sendRefund(ctx, repo, gateway, payment, refund, updateOrder)
+}
+
+// sendRefund sends a reserved refund through the gateway and records the
+// outcome.
+func sendRefund(ctx context.Context, repo *repository.PaymentRepository, gateway service.PaymentGateway, payment *domain.Payment, refund *domain.Refund, updateOrder bool) error {
+	if err := gateway.Refund(ctx, payment.TransactionID, refund.Amount); err != nil {
+		log.Printf("Gateway refused refund %d of payment %d: %v\n", refund.ID, payment.ID, err)
+		if err := repo.FailRefund(refund, err.Error()); err != nil {
+			log.Printf("Failed to mark refund %d failed: %v\n", refund.ID, err)
+		}
+		return err
+	}
+	return repo.CompleteRefund(payment, refund, updateOrder)
+}
+
+// releasePayment gives back what the payment holds on the card: an
+// authorization is voided and whatever is left of a charge is refunded. A
+// pending payment may still be authorizing, so the gateway is asked where it
+// stands first; if the gateway does not know it, the payment is failed and
+// CreatePayment releases a late authorization itself.
+func releasePayment(ctx context.Context, repo *repository.PaymentRepository, gateway service.PaymentGateway, payment *domain.Payment, reason string) error {
+	if payment.PaymentStatus == domain.PaymentStatusPending {
+		result, err := gateway.GetStatus(ctx, payment.InvoiceID)
+		var gatewayErr *domain.PaymentGatewayError
+		switch {
+		case errors.As(err, &gatewayErr):
+			return repo.TransitionPayment(payment, domain.PaymentStatusFailed, reason)
+		case err != nil:
+			return err
+		case result.Status != domain.PaymentStatusAuthorized && result.Status != domain.PaymentStatusCaptured:
+			return repo.TransitionPayment(payment, domain.PaymentStatusFailed, reason)
+		}
+
+		payment.TransactionID = result.TransactionID
+		if err := repo.TransitionPayment(payment, result.Status, "reported by the gateway"); err != nil {
+			return err
+		}
+	}
+
+	switch payment.PaymentStatus {
+	case domain.PaymentStatusAuthorized:
+		if err := gateway.Void(ctx, payment.TransactionID); err != nil {
+			return err
+		}
+		return repo.TransitionPayment(payment, domain.PaymentStatusVoided, reason)
+	case domain.PaymentStatusCaptured, domain.PaymentStatusPartiallyRefunded:
+		refundable, err := repo.RefundableAmount(payment)
+		if err != nil || refundable.Amount <= 0 {
+			return err
+		}
+		_, err = refundPayment(ctx, repo, gateway, payment, refundable, reason, false)
+		return err
+	}
+	return nil
+}
+
+func (h *PaymentHandler) GetPaymentEvents(c *gin.Context) {
+	payment, ok := h.paymentFromParam(c)
+	if !ok {
+		return
+	}
+
+	events, err := h.repo.GetPaymentEvents(payment.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving payment events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+func (h *PaymentHandler) paymentFromParam(c *gin.Context) (*domain.Payment, bool) {
+	if _, err := strconv.ParseUint(c.Param("id"), 10, 32); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return nil, false
+	}
+
+	payment, err := h.repo.GetPaymentByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return nil, false
+	}
+	return payment, true
+}
+
+func handlePaymentTransitionError(c *gin.Context, err error) {
+	var transitionErr *domain.InvalidPaymentTransitionError
+	if errors.As(err, &transitionErr) {
+		c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating payment status"})
+}
+
+func (h *PaymentHandler) GetPaymentByID(c *gin.Context) {
+	id := c.Param("id")
+	payment, err := h.repo.GetPaymentByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
 		return
 	}
 	c.JSON(http.StatusOK, payment)
 }
 
+// UpdatePayment rejects every change. A payment is sent to the gateway as
+// soon as it is created, so its order and amount are fixed from then on and
+// its status only changes through the payment lifecycle.
+func (h *PaymentHandler) UpdatePayment(c *gin.Context) {
+	if _, ok := h.paymentFromParam(c); !ok {
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "Payments cannot be changed once they are made"})
+}
+
+// DeletePayment removes a failed or voided payment. Payments that hold or
+// moved money are kept, as they make up the order's balance.
 func (h *PaymentHandler) DeletePayment(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.repo.DeletePayment(id); err != nil {
+	err := h.repo.DeletePayment(id)
+	var deleteErr *domain.PaymentDeleteError
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	case errors.As(err, &deleteErr):
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment in status '" + deleteErr.Status + "' cannot be deleted"})
+		return
+	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
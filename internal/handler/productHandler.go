@@ -4,11 +4,36 @@ import (
 	"e-commerce/internal/domain"
 	"e-commerce/internal/repository"
 	"e-commerce/internal/validation"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	"net/http"
+	"strings"
 )
 
+// productField is the struct fields a product update field is validated as
+// and the columns it sets.
+type productField struct {
+	names   []string
+	columns []string
+}
+
+// productFields maps the JSON fields of a product update to their struct
+// fields and columns. Fields missing here, such as backordered, are never
+// updated.
+var productFields = map[string]productField{
+	"name":                  {[]string{"Name"}, []string{"name"}},
+	"description":           {[]string{"Description"}, []string{"description"}},
+	"price":                 {[]string{"Price", "Price.Amount", "Price.Currency"}, []string{"price_amount", "price_currency"}},
+	"category":              {[]string{"Category"}, []string{"category"}},
+	"quantity":              {[]string{"Quantity"}, []string{"quantity"}},
+	"weight":                {[]string{"Weight"}, []string{"weight"}},
+	"allow_backorder":       {[]string{"AllowBackorder"}, []string{"allow_backorder"}},
+	"preorder_release_date": {[]string{"PreorderReleaseDate"}, []string{"preorder_release_date"}},
+	"max_backorder":         {[]string{"MaxBackorder"}, []string{"max_backorder"}},
+}
+
 type ProductHandler struct {
 	ProductRepo *repository.ProductRepository
 }
@@ -29,6 +54,9 @@ func (ph *ProductHandler) CreateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
 		return
 	}
+	defaultCurrency(&product.Price)
+	// The backorder counter is kept by the server.
+	product.Backordered = 0
 
 	if err := ph.ProductRepo.SaveProduct(&product); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving product"})
@@ -67,26 +95,38 @@ func (ph *ProductHandler) UpdateProduct(c *gin.Context) {
 	id := c.Param("id")
 
 	var product domain.Product
-	if err := c.BindJSON(&product); err != nil {
+	var fields map[string]json.RawMessage
+	if err := c.ShouldBindBodyWith(&product, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+	if err := c.ShouldBindBodyWith(&fields, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
 		return
 	}
 
-	if err := validation.ValidateStruct(&product); err != nil {
+	// Only the fields sent in the request are validated and updated.
+	var names, columns []string
+	for key := range fields {
+		if field, ok := productFields[strings.ToLower(key)]; ok {
+			names = append(names, field.names...)
+			columns = append(columns, field.columns...)
+		}
+	}
+
+	if err := validation.ValidateStructPartial(&product, names...); err != nil {
 		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.ProductBaseMessages)
 		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
 		return
 	}
+	defaultCurrency(&product.Price)
 
-	existingProduct, err := ph.ProductRepo.GetProductByID(id)
-	if err != nil {
+	if _, err := ph.ProductRepo.GetProductByID(id); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
 
-	product.CreatedAt = existingProduct.CreatedAt
-
-	if err := ph.ProductRepo.UpdateProduct(id, &product); err != nil {
+	if err := ph.ProductRepo.UpdateProduct(id, &product, columns); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating product"})
 		return
 	}
@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"context"
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"e-commerce/internal/service"
+	"e-commerce/internal/validation"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type ReturnHandler struct {
+	ReturnRepo  *repository.ReturnRepository
+	PaymentRepo *repository.PaymentRepository
+	Gateway     service.PaymentGateway
+}
+
+func NewReturnHandler(rr *repository.ReturnRepository, payr *repository.PaymentRepository, gateway service.PaymentGateway) *ReturnHandler {
+	return &ReturnHandler{ReturnRepo: rr, PaymentRepo: payr, Gateway: gateway}
+}
+
+func (h *ReturnHandler) CreateReturn(c *gin.Context) {
+	var ret domain.ReturnRequest
+	if err := c.BindJSON(&ret); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&ret); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.ReturnBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	if err := h.ReturnRepo.CreateReturn(&ret); err != nil {
+		var returnErr *domain.ReturnError
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		case errors.As(err, &returnErr):
+			c.JSON(http.StatusBadRequest, gin.H{"error": returnErr.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving return"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Return created successfully!", "return": ret})
+}
+
+func (h *ReturnHandler) GetAllReturns(c *gin.Context) {
+	var returns []domain.ReturnRequest
+	var err error
+	if orderIDStr := c.Query("order_id"); orderIDStr != "" {
+		orderID, parseErr := strconv.ParseUint(orderIDStr, 10, 32)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+			return
+		}
+		returns, err = h.ReturnRepo.GetReturnsByOrderID(uint(orderID))
+	} else {
+		returns, err = h.ReturnRepo.GetAllReturns()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving returns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, returns)
+}
+
+func (h *ReturnHandler) GetReturnByID(c *gin.Context) {
+	id, ok := returnIDParam(c)
+	if !ok {
+		return
+	}
+
+	ret, err := h.ReturnRepo.GetReturnByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ret)
+}
+
+func (h *ReturnHandler) ApproveReturn(c *gin.Context) {
+	h.decideReturn(c, domain.ReturnStatusApproved, "Return approved successfully!")
+}
+
+func (h *ReturnHandler) RejectReturn(c *gin.Context) {
+	h.decideReturn(c, domain.ReturnStatusRejected, "Return rejected successfully!")
+}
+
+func (h *ReturnHandler) decideReturn(c *gin.Context, status, message string) {
+	id, ok := returnIDParam(c)
+	if !ok {
+		return
+	}
+
+	var decision domain.ReturnDecision
+	if !bindReturnRequest(c, &decision) {
+		return
+	}
+
+	ret, err := h.ReturnRepo.TransitionReturn(id, status, decision.Actor, decision.Reason)
+	if err != nil {
+		handleReturnTransitionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "return": ret})
+}
+
+func (h *ReturnHandler) ReceiveReturn(c *gin.Context) {
+	id, ok := returnIDParam(c)
+	if !ok {
+		return
+	}
+
+	var receipt domain.ReturnReceipt
+	if !bindReturnRequest(c, &receipt) {
+		return
+	}
+
+	ret, err := h.ReturnRepo.ReceiveReturn(id, receipt.Actor, receipt.Restock, receipt.Reason)
+	if err != nil {
+		handleReturnTransitionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Return received successfully!", "return": ret})
+}
+
+func (h *ReturnHandler) RefundReturn(c *gin.Context) {
+	id, ok := returnIDParam(c)
+	if !ok {
+		return
+	}
+
+	var decision domain.ReturnDecision
+	if !bindReturnRequest(c, &decision) {
+		return
+	}
+
+	ret, err := h.ReturnRepo.StartRefund(id, decision.Actor, decision.Reason)
+	if err != nil {
+		handleReturnTransitionError(c, err)
+		return
+	}
+
+	err = h.refundPayments(c.Request.Context(), ret)
+	var transitionErr *domain.InvalidReturnTransitionError
+	switch {
+	case err == nil:
+	case errors.As(err, &transitionErr):
+		handleReturnTransitionError(c, err)
+		return
+	default:
+		log.Printf("Failed to refund payments for return %d: %v\n", ret.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Error refunding return payments. Refund the return again to retry.", "return": ret})
+		return
+	}
+
+	ret, err = h.ReturnRepo.RefundReturn(id, decision.Actor, decision.Reason)
+	if err != nil {
+		handleReturnTransitionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Return refunded successfully!", "return": ret})
+}
+
+// refundPayments sends what is left of the return's refund amount back
+// through the gateway, spread over the order's payments in the order they
+// were made. Each refund is reserved against the return first, so a retry
+// only refunds what earlier attempts did not.
+func (h *ReturnHandler) refundPayments(ctx context.Context, ret *domain.ReturnRequest) error {
+	payments, err := h.PaymentRepo.SearchPaymentsByOrderID(strconv.Itoa(int(ret.OrderID)))
+	if err != nil {
+		return err
+	}
+
+	for i := range payments {
+		payment := &payments[i]
+		refund, err := h.ReturnRepo.ReserveRefund(ret.ID, payment)
+		if err != nil {
+			return err
+		}
+		if refund == nil {
+			continue
+		}
+		if err := sendRefund(ctx, h.PaymentRepo, h.Gateway, payment, refund, false); err != nil {
+			return fmt.Errorf("payment %d: %v", payment.ID, err)
+		}
+	}
+	return nil
+}
+
+func (h *ReturnHandler) GetReturnHistory(c *gin.Context) {
+	id, ok := returnIDParam(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.ReturnRepo.GetReturnByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+		return
+	}
+
+	history, err := h.ReturnRepo.GetReturnStatusHistory(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving return history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+func returnIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid return ID"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func bindReturnRequest(c *gin.Context, request interface{}) bool {
+	if err := c.BindJSON(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return false
+	}
+
+	if err := validation.ValidateStruct(request); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.ReturnBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return false
+	}
+	return true
+}
+
+func handleReturnTransitionError(c *gin.Context, err error) {
+	var transitionErr *domain.InvalidReturnTransitionError
+	var orderTransitionErr *domain.InvalidTransitionError
+	var returnErr *domain.ReturnError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Return not found"})
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error(), "from": transitionErr.From, "to": transitionErr.To})
+	case errors.As(err, &orderTransitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": orderTransitionErr.Error(), "from": orderTransitionErr.From, "to": orderTransitionErr.To})
+	case errors.As(err, &returnErr):
+		c.JSON(http.StatusConflict, gin.H{"error": returnErr.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating return"})
+	}
+}
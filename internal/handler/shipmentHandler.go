@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"e-commerce/internal/validation"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+type ShipmentHandler struct {
+	ShipmentRepo *repository.ShipmentRepository
+}
+
+func NewShipmentHandler(sr *repository.ShipmentRepository) *ShipmentHandler {
+	return &ShipmentHandler{ShipmentRepo: sr}
+}
+
+func (h *ShipmentHandler) CreateShipment(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var request domain.ShipmentRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&request); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.ShippingBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	shipment, err := h.ShipmentRepo.CreateShipment(uint(id), &request)
+	if err != nil {
+		handleShipmentError(c, err, "Order not found")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Shipment created successfully!", "shipment": shipment})
+}
+
+func (h *ShipmentHandler) GetOrderShipments(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	shipments, err := h.ShipmentRepo.GetShipmentsByOrderID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving shipments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, shipments)
+}
+
+func (h *ShipmentHandler) GetShipmentByID(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipment ID"})
+		return
+	}
+
+	shipment, err := h.ShipmentRepo.GetShipmentByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, shipment)
+}
+
+func (h *ShipmentHandler) DeliverShipment(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipment ID"})
+		return
+	}
+
+	var delivery domain.ShipmentDelivery
+	if err := c.BindJSON(&delivery); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&delivery); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.ShippingBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	shipment, err := h.ShipmentRepo.DeliverShipment(uint(id), delivery.Actor)
+	if err != nil {
+		handleShipmentError(c, err, "Shipment not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Shipment delivered successfully!", "shipment": shipment})
+}
+
+func handleShipmentError(c *gin.Context, err error, notFound string) {
+	var transitionErr *domain.InvalidTransitionError
+	var fulfilmentErr *domain.FulfilmentError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+	case errors.As(err, &fulfilmentErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": fulfilmentErr.Error()})
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error(), "from": transitionErr.From, "to": transitionErr.To})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating shipment"})
+	}
+}
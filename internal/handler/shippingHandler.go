@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"e-commerce/internal/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type ShippingHandler struct {
+	ShippingRepo *repository.ShippingRepository
+}
+
+func NewShippingHandler(sr *repository.ShippingRepository) *ShippingHandler {
+	return &ShippingHandler{ShippingRepo: sr}
+}
+
+func (h *ShippingHandler) CreateShippingMethod(c *gin.Context) {
+	var method domain.ShippingMethod
+	if err := c.BindJSON(&method); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&method); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.ShippingBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	method.Code = strings.ToUpper(method.Code)
+	for i := range method.Rates {
+		rate := &method.Rates[i]
+		rate.Zone = strings.ToUpper(rate.Zone)
+		defaultCurrency(&rate.MinOrderTotal, &rate.MaxOrderTotal, &rate.Price)
+	}
+
+	if err := h.ShippingRepo.SaveShippingMethod(&method); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving shipping method"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, method)
+}
+
+func (h *ShippingHandler) GetAllShippingMethods(c *gin.Context) {
+	methods, err := h.ShippingRepo.GetAllShippingMethods()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving shipping methods"})
+		return
+	}
+
+	c.JSON(http.StatusOK, methods)
+}
+
+func (h *ShippingHandler) GetShippingMethodByID(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping method ID"})
+		return
+	}
+
+	method, err := h.ShippingRepo.GetShippingMethodByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipping method not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, method)
+}
+
+func (h *ShippingHandler) DeleteShippingMethod(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shipping method ID"})
+		return
+	}
+
+	if err := h.ShippingRepo.DeleteShippingMethod(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting shipping method"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Shipping method deleted successfully!"})
+}
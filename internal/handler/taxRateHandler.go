@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"e-commerce/internal/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"strings"
+)
+
+type TaxRateHandler struct {
+	TaxRateRepo *repository.TaxRateRepository
+}
+
+func NewTaxRateHandler(tr *repository.TaxRateRepository) *TaxRateHandler {
+	return &TaxRateHandler{TaxRateRepo: tr}
+}
+
+func (h *TaxRateHandler) CreateTaxRate(c *gin.Context) {
+	var rate domain.TaxRate
+	if err := c.BindJSON(&rate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Error decoding request body"})
+		return
+	}
+
+	if err := validation.ValidateStruct(&rate); err != nil {
+		errorMessage := validation.HandleValidationErrors(err.(validator.ValidationErrors), domain.TaxRateBaseMessages)
+		c.JSON(http.StatusBadRequest, gin.H{"error": errorMessage})
+		return
+	}
+
+	rate.Country = strings.ToUpper(rate.Country)
+	if err := h.TaxRateRepo.SaveTaxRate(&rate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving tax rate"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rate)
+}
+
+func (h *TaxRateHandler) GetAllTaxRates(c *gin.Context) {
+	rates, err := h.TaxRateRepo.GetAllTaxRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving tax rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+func (h *TaxRateHandler) DeleteTaxRate(c *gin.Context) {
+	if err := h.TaxRateRepo.DeleteTaxRate(c.Param("id")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting tax rate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tax rate deleted successfully!"})
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"e-commerce/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CartRepository struct {
+	DB *gorm.DB
+}
+
+func NewCartRepository(db *gorm.DB) *CartRepository {
+	return &CartRepository{DB: db}
+}
+
+// GetCartByUserID returns the user's cart, creating an empty one on first use.
+func (cr *CartRepository) GetCartByUserID(userID uint) (*domain.Cart, error) {
+	var cart domain.Cart
+	err := cr.DB.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where(domain.Cart{UserID: userID}).FirstOrCreate(&cart).Error
+	if err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// AddItem adds the quantity to the product's line, creating the line if the
+// product is not in the cart yet.
+func (cr *CartRepository) AddItem(userID, productID uint, quantity int) error {
+	cart, err := cr.GetCartByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	item := domain.CartItem{CartID: cart.ID, ProductID: productID, Quantity: quantity}
+	return cr.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cart_id"}, {Name: "product_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"quantity": gorm.Expr("cart_items.quantity + excluded.quantity")}),
+	}).Create(&item).Error
+}
+
+func (cr *CartRepository) UpdateItemQuantity(userID, productID uint, quantity int) error {
+	cart, err := cr.GetCartByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	result := cr.DB.Model(&domain.CartItem{}).
+		Where("cart_id = ? AND product_id = ?", cart.ID, productID).
+		Update("quantity", quantity)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (cr *CartRepository) RemoveItem(userID, productID uint) error {
+	cart, err := cr.GetCartByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	result := cr.DB.Where("cart_id = ? AND product_id = ?", cart.ID, productID).Delete(&domain.CartItem{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"e-commerce/internal/domain"
+	"gorm.io/gorm"
+)
+
+type CouponRepository struct {
+	DB *gorm.DB
+}
+
+func NewCouponRepository(db *gorm.DB) *CouponRepository {
+	return &CouponRepository{DB: db}
+}
+
+func (cr *CouponRepository) SaveCoupon(coupon *domain.Coupon) error {
+	return cr.DB.Create(coupon).Error
+}
+
+func (cr *CouponRepository) GetAllCoupons() ([]domain.Coupon, error) {
+	var coupons []domain.Coupon
+	err := cr.DB.Order("id").Find(&coupons).Error
+	return coupons, err
+}
+
+func (cr *CouponRepository) GetCouponByCode(code string) (*domain.Coupon, error) {
+	var coupon domain.Coupon
+	if err := cr.DB.Where("code = ?", code).First(&coupon).Error; err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+func (cr *CouponRepository) UpdateCoupon(code string, updatedCoupon *domain.Coupon) error {
+	return cr.DB.Model(&domain.Coupon{}).
+		Select("type", "value", "amount_amount", "amount_currency", "min_order_value_amount", "min_order_value_currency", "starts_at", "expires_at", "usage_limit", "per_user_limit", "product_ids", "categories", "disabled").
+		Where("code = ?", code).
+		Updates(updatedCoupon).Error
+}
+
+func (cr *CouponRepository) DeleteCoupon(code string) error {
+	return cr.DB.Where("code = ?", code).Delete(&domain.Coupon{}).Error
+}
+
+func (cr *CouponRepository) GetRedemptions(couponID uint) ([]domain.CouponRedemption, error) {
+	var redemptions []domain.CouponRedemption
+	err := cr.DB.Where("coupon_id = ?", couponID).Order("id").Find(&redemptions).Error
+	return redemptions, err
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"e-commerce/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type ExchangeRateRepository struct {
+	DB *gorm.DB
+}
+
+func NewExchangeRateRepository(db *gorm.DB) *ExchangeRateRepository {
+	return &ExchangeRateRepository{DB: db}
+}
+
+// SaveExchangeRate stores the rate for its currency pair, replacing the
+// previous one.
+func (er *ExchangeRateRepository) SaveExchangeRate(rate *domain.ExchangeRate) error {
+	rate.UpdatedAt = time.Now()
+	return er.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "from_currency"}, {Name: "to_currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).Create(rate).Error
+}
+
+func (er *ExchangeRateRepository) GetAllExchangeRates() ([]domain.ExchangeRate, error) {
+	var rates []domain.ExchangeRate
+	err := er.DB.Order("from_currency, to_currency").Find(&rates).Error
+	return rates, err
+}
+
+func (er *ExchangeRateRepository) GetExchangeRate(from, to string) (*domain.ExchangeRate, error) {
+	var rate domain.ExchangeRate
+	if err := er.DB.Where("from_currency = ? AND to_currency = ?", from, to).First(&rate).Error; err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+func (er *ExchangeRateRepository) DeleteExchangeRate(id string) error {
+	return er.DB.Where("id = ?", id).Delete(&domain.ExchangeRate{}).Error
+}
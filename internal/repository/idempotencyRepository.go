@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"e-commerce/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type IdempotencyRepository struct {
+	DB *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) *IdempotencyRepository {
+	return &IdempotencyRepository{DB: db}
+}
+
+// BeginRequest claims the key for a new request. If the key is already held
+// by an unexpired request, that record is returned and started is false.
+func (ir *IdempotencyRepository) BeginRequest(key, fingerprint string, ttl time.Duration) (record *domain.IdempotencyKey, started bool, err error) {
+	record = &domain.IdempotencyKey{}
+	err = ir.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		claim := domain.IdempotencyKey{Key: key, Fingerprint: fingerprint, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&claim)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			*record = claim
+			started = true
+			return nil
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(record).Error; err != nil {
+			return err
+		}
+		if record.ExpiresAt.After(now) {
+			return nil
+		}
+
+		if err := tx.Save(&claim).Error; err != nil {
+			return err
+		}
+		*record = claim
+		started = true
+		return nil
+	})
+	return record, started, err
+}
+
+func (ir *IdempotencyRepository) CompleteRequest(key string, statusCode int, contentType string, body []byte) error {
+	return ir.DB.Model(&domain.IdempotencyKey{}).Where("key = ?", key).Updates(map[string]interface{}{
+		"completed":     true,
+		"status_code":   statusCode,
+		"content_type":  contentType,
+		"response_body": body,
+	}).Error
+}
+
+// ReleaseRequest forgets the key so that the client may retry a request
+// that failed on our side.
+func (ir *IdempotencyRepository) ReleaseRequest(key string) error {
+	return ir.DB.Where("key = ?", key).Delete(&domain.IdempotencyKey{}).Error
+}
+
+// PurgeExpired deletes the keys that expired before now.
+func (ir *IdempotencyRepository) PurgeExpired(now time.Time) (int64, error) {
+	result := ir.DB.Where("expires_at < ?", now).Delete(&domain.IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
@@ -2,7 +2,10 @@ package repository
 
 import (
 	"e-commerce/internal/domain"
+	"fmt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
 )
 
 type OrderRepository struct {
@@ -13,52 +16,542 @@ func NewOrderRepository(db *gorm.DB) *OrderRepository {
 	return &OrderRepository{DB: db}
 }
 
+// SaveOrder reserves stock for every item and inserts the order in a single
+// transaction. Product rows are locked in ID order so concurrent orders for
+// the same products serialize instead of overselling or deadlocking.
 func (or *OrderRepository) SaveOrder(order *domain.Order) error {
-	return or.DB.Create(&order).Error
+	return or.DB.Transaction(func(tx *gorm.DB) error {
+		return saveOrder(tx, order)
+	})
+}
+
+// SaveCartOrder saves an order placed from the cart and empties the cart in
+// the same transaction. If the cart items no longer match the ones the order
+// was built from, nothing is saved and a CartChangedError is returned, so a
+// cart cannot be checked out twice.
+func (or *OrderRepository) SaveCartOrder(order *domain.Order, cart *domain.Cart) error {
+	return or.DB.Transaction(func(tx *gorm.DB) error {
+		if err := saveOrder(tx, order); err != nil {
+			return err
+		}
+
+		for _, item := range cart.Items {
+			result := tx.Where("id = ? AND cart_id = ? AND quantity = ?", item.ID, cart.ID, item.Quantity).Delete(&domain.CartItem{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return &domain.CartChangedError{}
+			}
+		}
+
+		var remaining int64
+		if err := tx.Model(&domain.CartItem{}).Where("cart_id = ?", cart.ID).Count(&remaining).Error; err != nil {
+			return err
+		}
+		if remaining > 0 {
+			return &domain.CartChangedError{}
+		}
+		return nil
+	})
+}
+
+// saveOrder must run inside a transaction.
+func saveOrder(tx *gorm.DB, order *domain.Order) error {
+	if err := reserveStock(tx, order.Items); err != nil {
+		return err
+	}
+
+	if err := tx.Omit(clause.Associations).Create(order).Error; err != nil {
+		return err
+	}
+
+	for i := range order.Items {
+		order.Items[i].OrderID = order.ID
+	}
+	if len(order.Items) > 0 {
+		if err := tx.Create(&order.Items).Error; err != nil {
+			return err
+		}
+	}
+
+	for i := range order.TaxLines {
+		order.TaxLines[i].OrderID = order.ID
+	}
+	if len(order.TaxLines) > 0 {
+		if err := tx.Create(&order.TaxLines).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := redeemCoupon(tx, order); err != nil {
+		return err
+	}
+
+	history := domain.OrderStatusHistory{
+		OrderID:  order.ID,
+		ToStatus: order.Status,
+		Actor:    fmt.Sprintf("user:%d", order.UserID),
+		Reason:   "order placed",
+	}
+	if err := tx.Create(&history).Error; err != nil {
+		return err
+	}
+
+	order.FillProductIDs()
+	return nil
 }
 
 func (or *OrderRepository) GetOrderById(id uint) (*domain.Order, error) {
 	var order domain.Order
-	if err := or.DB.Where("id = ?", id).First(&order).Error; err != nil {
+	if err := or.DB.Preload("Items").Preload("TaxLines").Where("id = ?", id).First(&order).Error; err != nil {
 		return nil, err
 	}
+	order.FillProductIDs()
 	return &order, nil
 }
 
 func (or *OrderRepository) GetAllOrders() ([]domain.Order, error) {
 	var orders []domain.Order
-	if err := or.DB.Find(&orders).Error; err != nil {
+	if err := or.DB.Preload("Items").Preload("TaxLines").Find(&orders).Error; err != nil {
 		return nil, err
 	}
+	fillProductIDs(orders)
 	return orders, nil
 }
 
+// UpdateOrder changes the shipping address of the order. Every other field
+// is either priced, set at checkout, or owned by the order lifecycle, so it
+// is never written here.
 func (or *OrderRepository) UpdateOrder(id uint, updatedOrder *domain.Order) error {
-	if err := or.DB.Model(&domain.Order{}).Where("id = ?", id).Updates(updatedOrder).Error; err != nil {
+	editable := domain.Order{ShippingAddress: updatedOrder.ShippingAddress}
+	if err := or.DB.Model(&domain.Order{}).Where("id = ?", id).Updates(&editable).Error; err != nil {
 		return err
 	}
 	return nil
 }
 
-func (or *OrderRepository) DeleteOrder(id uint) error {
-	if err := or.DB.Where("id = ?", id).Delete(&domain.Order{}).Error; err != nil {
-		return err
+// TransitionOrder moves the order to a new status if the lifecycle allows it
+// and records the change in the status history.
+func (or *OrderRepository) TransitionOrder(id uint, status, actor, reason string) (*domain.Order, error) {
+	var order domain.Order
+	err := or.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, id, &order); err != nil {
+			return err
+		}
+		return transitionOrder(tx, &order, status, actor, reason)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return &order, nil
+}
+
+// CancelOrder marks the order cancelled and returns its reserved units to
+// stock. Orders that have already shipped cannot be cancelled.
+func (or *OrderRepository) CancelOrder(id uint, actor, reason string) (*domain.Order, error) {
+	var order domain.Order
+	err := or.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockOrder(tx, id, &order); err != nil {
+			return err
+		}
+		if err := transitionOrder(tx, &order, domain.OrderStatusCancelled, actor, reason); err != nil {
+			return err
+		}
+		if err := releaseStock(tx, order.Items); err != nil {
+			return err
+		}
+		return releaseCoupon(tx, order.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	order.FillProductIDs()
+	return &order, nil
+}
+
+// ExpireOrders cancels up to limit orders that have been awaiting payment
+// since before cutoff and have no payment, releasing their stock and coupon
+// uses. Rows locked by another transaction are skipped, so several workers
+// can run at once without expiring the same order twice.
+func (or *OrderRepository) ExpireOrders(cutoff time.Time, limit int, actor, reason string) ([]domain.Order, error) {
+	var orders []domain.Order
+	err := or.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND order_date < ?", domain.OrderStatusPendingPayment, cutoff).
+			Where("NOT EXISTS (SELECT 1 FROM payments WHERE payments.order_id = orders.id AND payments.status IN ?)", domain.ActivePaymentStatuses).
+			Order("order_date, id").
+			Limit(limit).
+			Find(&orders).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range orders {
+			order := &orders[i]
+			if err := tx.Where("order_id = ?", order.ID).Find(&order.Items).Error; err != nil {
+				return err
+			}
+			if err := transitionOrder(tx, order, domain.OrderStatusCancelled, actor, reason); err != nil {
+				return err
+			}
+			if err := releaseStock(tx, order.Items); err != nil {
+				return err
+			}
+			if err := releaseCoupon(tx, order.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fillProductIDs(orders)
+	return orders, nil
+}
+
+func (or *OrderRepository) GetOrderStatusHistory(id uint) ([]domain.OrderStatusHistory, error) {
+	var history []domain.OrderStatusHistory
+	if err := or.DB.Where("order_id = ?", id).Order("created_at, id").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// DeleteOrder removes a cancelled order. Cancelling has already returned its
+// stock and coupon use; orders with payments, shipments or returns are kept
+// so those records never point at a missing order.
+func (or *OrderRepository) DeleteOrder(id uint) error {
+	return or.DB.Transaction(func(tx *gorm.DB) error {
+		var order domain.Order
+		if err := lockOrder(tx, id, &order); err != nil {
+			return err
+		}
+		if order.Status != domain.OrderStatusCancelled {
+			return &domain.OrderDeleteError{Reason: "only cancelled orders can be deleted, this one is '" + order.Status + "'"}
+		}
+		for _, related := range []struct {
+			name  string
+			model interface{}
+		}{
+			{"payments", &domain.Payment{}},
+			{"shipments", &domain.Shipment{}},
+			{"returns", &domain.ReturnRequest{}},
+		} {
+			var count int64
+			if err := tx.Model(related.model).Where("order_id = ?", id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return &domain.OrderDeleteError{Reason: "it has " + related.name}
+			}
+		}
+
+		if err := tx.Where("order_id = ?", id).Delete(&domain.OrderStatusHistory{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("order_id = ?", id).Delete(&domain.OrderTaxLine{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("order_id = ?", id).Delete(&domain.OrderItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&domain.Order{}).Error
+	})
 }
 
 func (or *OrderRepository) SearchOrdersByUserID(userID string) ([]domain.Order, error) {
 	var orders []domain.Order
-	if err := or.DB.Where("user_id = ?", userID).Find(&orders).Error; err != nil {
+	if err := or.DB.Preload("Items").Preload("TaxLines").Where("user_id = ?", userID).Find(&orders).Error; err != nil {
 		return nil, err
 	}
+	fillProductIDs(orders)
 	return orders, nil
 }
 
 func (or *OrderRepository) SearchOrdersByStatus(status string) ([]domain.Order, error) {
 	var orders []domain.Order
-	if err := or.DB.Where("status = ?", status).Find(&orders).Error; err != nil {
+	if err := or.DB.Preload("Items").Preload("TaxLines").Where("status = ?", status).Find(&orders).Error; err != nil {
 		return nil, err
 	}
+	fillProductIDs(orders)
 	return orders, nil
 }
+
+// FindOrders returns at most filter.Limit orders matching the filter, in
+// keyset order so that pages stay stable while new orders come in.
+func (or *OrderRepository) FindOrders(filter *domain.OrderFilter) ([]domain.Order, error) {
+	query := applyOrderFilter(or.DB.Model(&domain.Order{}), filter)
+
+	var orders []domain.Order
+	if err := query.Limit(filter.Limit).Preload("Items").Preload("TaxLines").Find(&orders).Error; err != nil {
+		return nil, err
+	}
+	fillProductIDs(orders)
+	return orders, nil
+}
+
+// ExportOrders streams one row per order item matching the filter, joined
+// with its order, user and product, in the filter's sort order. The filter's
+// limit is ignored.
+func (or *OrderRepository) ExportOrders(filter *domain.OrderFilter, fn func(*domain.OrderExportRow) error) error {
+	query := applyOrderFilter(or.DB.Table("orders"), filter).
+		Select(`orders.id AS order_id, orders.order_date, orders.status, orders.user_id,
+			COALESCE(users.name, '') AS user_name, COALESCE(users.email, '') AS user_email,
+			order_items.product_id, COALESCE(products.name, '') AS product_name, order_items.quantity,
+			order_items.unit_price_amount, order_items.unit_price_currency,
+			order_items.line_total_amount, order_items.line_total_currency,
+			order_items.discount_amount, order_items.discount_currency,
+			orders.total_price_amount, orders.total_price_currency,
+			orders.base_total_amount, orders.base_total_currency`).
+		Joins("JOIN order_items ON order_items.order_id = orders.id").
+		Joins("LEFT JOIN users ON users.id = orders.user_id").
+		Joins("LEFT JOIN products ON products.id = order_items.product_id").
+		Order("order_items.id")
+	return streamRows(query, fn)
+}
+
+// applyOrderFilter adds the filter's conditions, cursor and sort order to an
+// orders query.
+func applyOrderFilter(query *gorm.DB, filter *domain.OrderFilter) *gorm.DB {
+	if filter.UserID != nil {
+		query = query.Where("orders.user_id = ?", *filter.UserID)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("orders.status IN ?", filter.Statuses)
+	}
+	if filter.From != nil {
+		query = query.Where("orders.order_date >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("orders.order_date < ?", *filter.To)
+	}
+	if filter.MinTotal != nil {
+		query = query.Where("orders.base_total_amount >= ?", filter.MinTotal.Amount)
+	}
+	if filter.MaxTotal != nil {
+		query = query.Where("orders.base_total_amount <= ?", filter.MaxTotal.Amount)
+	}
+	if filter.ProductID != nil {
+		query = query.Where("EXISTS (SELECT 1 FROM order_items AS filtered WHERE filtered.order_id = orders.id AND filtered.product_id = ?)", *filter.ProductID)
+	}
+
+	column := "orders." + filter.SortBy
+	if filter.SortBy == domain.OrderSortTotal {
+		column = "orders.base_total_amount"
+	}
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if after := filter.After; after != nil {
+		switch filter.SortBy {
+		case domain.OrderSortDate:
+			query = query.Where("(orders.order_date, orders.id) "+comparison+" (?, ?)", after.OrderDate, after.ID)
+		case domain.OrderSortTotal:
+			query = query.Where("(orders.base_total_amount, orders.id) "+comparison+" (?, ?)", after.BaseTotal, after.ID)
+		default:
+			query = query.Where("orders.id "+comparison+" ?", after.ID)
+		}
+	}
+	if filter.SortBy != domain.OrderSortID {
+		query = query.Order(column + " " + direction)
+	}
+	return query.Order("orders.id " + direction)
+}
+
+func fillProductIDs(orders []domain.Order) {
+	for i := range orders {
+		orders[i].FillProductIDs()
+	}
+}
+
+// reserveStock takes the ordered units out of stock. Units beyond the stock
+// of a product that accepts backorders are recorded as backordered on the
+// order items instead, starting from the last item.
+func reserveStock(tx *gorm.DB, items []domain.OrderItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	requested := make(map[uint]int, len(items))
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		if _, ok := requested[item.ProductID]; !ok {
+			ids = append(ids, item.ProductID)
+		}
+		requested[item.ProductID] += item.Quantity
+	}
+
+	var products []domain.Product
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", ids).
+		Order("id").
+		Find(&products).Error; err != nil {
+		return err
+	}
+
+	byID := make(map[uint]*domain.Product, len(products))
+	for i := range products {
+		byID[products[i].ID] = &products[i]
+	}
+
+	now := time.Now()
+	backordered := make(map[uint]int)
+	var shortages []domain.StockShortage
+	for _, id := range ids {
+		available, capacity := 0, 0
+		if product, ok := byID[id]; ok {
+			available = product.Quantity
+			capacity = product.BackorderCapacity(now)
+		}
+
+		missing := requested[id] - available
+		if missing <= 0 {
+			continue
+		}
+		if capacity >= 0 && missing > capacity {
+			shortages = append(shortages, domain.StockShortage{
+				ProductID: id,
+				Requested: requested[id],
+				Available: available + capacity,
+			})
+			continue
+		}
+		backordered[id] = missing
+	}
+	if len(shortages) > 0 {
+		return &domain.InsufficientStockError{Items: shortages}
+	}
+
+	for _, id := range ids {
+		if err := tx.Model(&domain.Product{}).
+			Where("id = ?", id).
+			Updates(map[string]interface{}{
+				"quantity":    gorm.Expr("quantity - ?", requested[id]-backordered[id]),
+				"backordered": gorm.Expr("backordered + ?", backordered[id]),
+			}).Error; err != nil {
+			return err
+		}
+	}
+
+	for i := len(items) - 1; i >= 0; i-- {
+		item := &items[i]
+		item.Backordered = min(item.Quantity, backordered[item.ProductID])
+		backordered[item.ProductID] -= item.Backordered
+	}
+	return nil
+}
+
+// releaseStock returns the unshipped units allocated to the items to stock
+// and removes their backordered units from the products' backorder queues.
+func releaseStock(tx *gorm.DB, items []domain.OrderItem) error {
+	for i := range items {
+		item := &items[i]
+		if err := tx.Model(&domain.Product{}).
+			Where("id = ?", item.ProductID).
+			Updates(map[string]interface{}{
+				"quantity":    gorm.Expr("quantity + ?", item.Shippable()),
+				"backordered": gorm.Expr("backordered - ?", item.Backordered),
+			}).Error; err != nil {
+			return err
+		}
+
+		if item.Backordered > 0 {
+			if err := tx.Model(&domain.OrderItem{}).Where("id = ?", item.ID).Update("backordered", 0).Error; err != nil {
+				return err
+			}
+			item.Backordered = 0
+		}
+	}
+	return nil
+}
+
+// redeemCoupon records the order's coupon use. The coupon row is locked so
+// that concurrent checkouts cannot exceed its usage limits.
+func redeemCoupon(tx *gorm.DB, order *domain.Order) error {
+	if order.CouponID == nil {
+		return nil
+	}
+
+	var coupon domain.Coupon
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", *order.CouponID).First(&coupon).Error; err != nil {
+		return err
+	}
+
+	if coupon.UsageLimit > 0 && coupon.UsageCount >= coupon.UsageLimit {
+		return &domain.CouponError{Code: coupon.Code, Reason: "has reached its usage limit"}
+	}
+
+	if coupon.PerUserLimit > 0 {
+		var used int64
+		if err := tx.Model(&domain.CouponRedemption{}).
+			Where("coupon_id = ? AND user_id = ?", coupon.ID, order.UserID).
+			Count(&used).Error; err != nil {
+			return err
+		}
+		if int(used) >= coupon.PerUserLimit {
+			return &domain.CouponError{Code: coupon.Code, Reason: "has already been used the maximum number of times by this user"}
+		}
+	}
+
+	redemption := domain.CouponRedemption{
+		CouponID: coupon.ID,
+		UserID:   order.UserID,
+		OrderID:  order.ID,
+		Discount: order.DiscountTotal,
+	}
+	if err := tx.Create(&redemption).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&domain.Coupon{}).
+		Where("id = ?", coupon.ID).
+		Update("usage_count", gorm.Expr("usage_count + 1")).Error
+}
+
+func releaseCoupon(tx *gorm.DB, orderID uint) error {
+	var redemption domain.CouponRedemption
+	err := tx.Where("order_id = ?", orderID).Limit(1).Find(&redemption).Error
+	if err != nil || redemption.ID == 0 {
+		return err
+	}
+
+	if err := tx.Delete(&redemption).Error; err != nil {
+		return err
+	}
+	return tx.Model(&domain.Coupon{}).
+		Where("id = ?", redemption.CouponID).
+		Update("usage_count", gorm.Expr("usage_count - 1")).Error
+}
+
+func lockOrder(tx *gorm.DB, id uint, order *domain.Order) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").Where("id = ?", id).First(order).Error
+}
+
+// transitionOrder must run inside a transaction that holds the order row lock.
+func transitionOrder(tx *gorm.DB, order *domain.Order, status, actor, reason string) error {
+	if !domain.CanTransitionOrder(order.Status, status) {
+		return &domain.InvalidTransitionError{From: order.Status, To: status}
+	}
+
+	if err := tx.Model(&domain.Order{}).Where("id = ?", order.ID).Update("status", status).Error; err != nil {
+		return err
+	}
+
+	history := domain.OrderStatusHistory{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   status,
+		Actor:      actor,
+		Reason:     reason,
+	}
+	if err := tx.Create(&history).Error; err != nil {
+		return err
+	}
+
+	order.Status = status
+	return nil
+}
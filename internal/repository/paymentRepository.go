@@ -2,7 +2,10 @@ package repository
 
 import (
 	"e-commerce/internal/domain"
+	"fmt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
 )
 
 type PaymentRepository struct {
@@ -21,8 +24,180 @@ func (repo *PaymentRepository) GetAllPayments() ([]domain.Payment, error) {
 	return payments, err
 }
 
+// CreatePayment saves a new pending payment and records its first event.
 func (repo *PaymentRepository) CreatePayment(payment *domain.Payment) error {
-	return repo.DB.Create(payment).Error
+	payment.PaymentStatus = domain.PaymentStatusPending
+	return repo.DB.Transaction(func(tx *gorm.DB) error {
+		return insertPayment(tx, payment)
+	})
+}
+
+// CreateOrderPayment saves a new pending payment for the outstanding balance
+// of its order. The order row stays locked until the payment is inserted, so
+// concurrent payments for the same order cannot both pass the balance check.
+// A zero amount defaults to the whole balance.
+func (repo *PaymentRepository) CreateOrderPayment(payment *domain.Payment) error {
+	return repo.DB.Transaction(func(tx *gorm.DB) error {
+		var order domain.Order
+		if err := lockOrder(tx, payment.OrderID, &order); err != nil {
+			return err
+		}
+		if order.Status != domain.OrderStatusPendingPayment {
+			return &domain.InvalidTransitionError{From: order.Status, To: domain.OrderStatusPaid}
+		}
+
+		paid, err := totalPaid(tx, order.ID)
+		if err != nil {
+			return err
+		}
+		outstanding := order.TotalPrice.Sub(domain.NewMoney(paid, order.TotalPrice.Currency))
+		if outstanding.Amount <= 0 {
+			return &domain.PaymentAmountError{Outstanding: domain.NewMoney(0, outstanding.Currency)}
+		}
+
+		if payment.Amount.Currency == "" {
+			payment.Amount.Currency = outstanding.Currency
+		}
+		if payment.Amount.IsZero() {
+			payment.Amount = outstanding
+		}
+		if payment.Amount != outstanding {
+			return &domain.PaymentAmountError{Outstanding: outstanding}
+		}
+
+		payment.UserID = order.UserID
+		payment.PaymentStatus = domain.PaymentStatusPending
+		return insertPayment(tx, payment)
+	})
+}
+
+// insertPayment saves the payment with its first event. A payment without an
+// invoice ID gets one derived from its own ID, which keeps it unique.
+func insertPayment(tx *gorm.DB, payment *domain.Payment) error {
+	if err := tx.Create(payment).Error; err != nil {
+		return err
+	}
+	if payment.InvoiceID == "" {
+		payment.InvoiceID = fmt.Sprintf("%06d", payment.ID)
+		if err := tx.Model(payment).Update("invoice_id", payment.InvoiceID).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Create(&domain.PaymentEvent{PaymentID: payment.ID, ToStatus: payment.PaymentStatus}).Error
+}
+
+// TransitionPayment moves the payment to status and records the change. The
+// payment's transaction reference is saved with it, and a capture marks the
+// order paid.
+func (repo *PaymentRepository) TransitionPayment(payment *domain.Payment, status, reason string) error {
+	return repo.DB.Transaction(func(tx *gorm.DB) error {
+		return transitionPayment(tx, payment, status, reason)
+	})
+}
+
+// CreateRefund reserves a pending refund against the payment. The payment
+// row is locked so that concurrent refunds cannot exceed the captured amount.
+func (repo *PaymentRepository) CreateRefund(payment *domain.Payment, refund *domain.Refund) error {
+	return repo.DB.Transaction(func(tx *gorm.DB) error {
+		var current domain.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", payment.ID).First(&current).Error; err != nil {
+			return err
+		}
+		if current.PaymentStatus != domain.PaymentStatusCaptured && current.PaymentStatus != domain.PaymentStatusPartiallyRefunded {
+			return &domain.InvalidPaymentTransitionError{From: current.PaymentStatus, To: domain.PaymentStatusRefunded}
+		}
+
+		refundable, err := refundableAmount(tx, &current)
+		if err != nil {
+			return err
+		}
+		if refund.Amount.Currency != refundable.Currency || refund.Amount.Amount > refundable.Amount {
+			return &domain.RefundLimitError{Refundable: refundable}
+		}
+
+		refund.PaymentID = payment.ID
+		refund.Status = domain.RefundStatusPending
+		return tx.Create(refund).Error
+	})
+}
+
+// FailRefund marks a refund the gateway rejected, which releases its amount.
+func (repo *PaymentRepository) FailRefund(refund *domain.Refund, reason string) error {
+	refund.Status = domain.RefundStatusFailed
+	refund.FailureReason = reason
+	return repo.DB.Model(refund).Select("status", "failure_reason").Updates(refund).Error
+}
+
+// CompleteRefund marks the refund completed and moves the payment to
+// refunded once its whole amount has been returned, or to partially_refunded.
+// With updateOrder the order follows once its lifecycle allows it: it is
+// refunded when none of its payments holds money any more.
+func (repo *PaymentRepository) CompleteRefund(payment *domain.Payment, refund *domain.Refund, updateOrder bool) error {
+	return repo.DB.Transaction(func(tx *gorm.DB) error {
+		refund.Status = domain.RefundStatusCompleted
+		if err := tx.Model(refund).Update("status", refund.Status).Error; err != nil {
+			return err
+		}
+
+		var refunded int64
+		err := tx.Model(&domain.Refund{}).
+			Select("COALESCE(SUM(amount_amount), 0)").
+			Where("payment_id = ? AND status = ?", payment.ID, domain.RefundStatusCompleted).
+			Scan(&refunded).Error
+		if err != nil {
+			return err
+		}
+
+		status := domain.PaymentStatusPartiallyRefunded
+		if refunded >= payment.Amount.Amount {
+			status = domain.PaymentStatusRefunded
+		}
+		if err := transitionPayment(tx, payment, status, refund.Reason); err != nil {
+			return err
+		}
+		if !updateOrder {
+			return nil
+		}
+
+		var order domain.Order
+		if err := lockOrder(tx, payment.OrderID, &order); err != nil {
+			return err
+		}
+		var charged int64
+		err = tx.Model(&domain.Payment{}).
+			Where("order_id = ? AND status IN ?", order.ID, []string{domain.PaymentStatusCaptured, domain.PaymentStatusPartiallyRefunded}).
+			Count(&charged).Error
+		if err != nil {
+			return err
+		}
+
+		orderStatus := domain.OrderStatusPartiallyRefunded
+		if charged == 0 {
+			orderStatus = domain.OrderStatusRefunded
+		}
+		if !domain.CanTransitionOrder(order.Status, orderStatus) {
+			return nil
+		}
+		return transitionOrder(tx, &order, orderStatus, "system:payments", fmt.Sprintf("payment %d %s", payment.ID, status))
+	})
+}
+
+// RefundableAmount is what is left of the payment after its pending and
+// completed refunds.
+func (repo *PaymentRepository) RefundableAmount(payment *domain.Payment) (domain.Money, error) {
+	return refundableAmount(repo.DB, payment)
+}
+
+func (repo *PaymentRepository) GetRefunds(paymentID uint) ([]domain.Refund, error) {
+	var refunds []domain.Refund
+	err := repo.DB.Where("payment_id = ?", paymentID).Order("created_at, id").Find(&refunds).Error
+	return refunds, err
+}
+
+func (repo *PaymentRepository) GetPaymentEvents(paymentID uint) ([]domain.PaymentEvent, error) {
+	var events []domain.PaymentEvent
+	err := repo.DB.Where("payment_id = ?", paymentID).Order("created_at, id").Find(&events).Error
+	return events, err
 }
 
 func (repo *PaymentRepository) GetPaymentByID(id string) (*domain.Payment, error) {
@@ -31,12 +206,33 @@ func (repo *PaymentRepository) GetPaymentByID(id string) (*domain.Payment, error
 	return &payment, err
 }
 
-func (repo *PaymentRepository) UpdatePayment(payment *domain.Payment) error {
-	return repo.DB.Save(payment).Error
+func (repo *PaymentRepository) GetPaymentByInvoiceID(invoiceID string) (*domain.Payment, error) {
+	var payment domain.Payment
+	err := repo.DB.First(&payment, "invoice_id = ?", invoiceID).Error
+	return &payment, err
 }
 
+// DeletePayment removes a failed or voided payment together with its events
+// and refunds. Other payments count towards their order's balance and are
+// kept.
 func (repo *PaymentRepository) DeletePayment(id string) error {
-	return repo.DB.Delete(&domain.Payment{}, "id = ?", id).Error
+	return repo.DB.Transaction(func(tx *gorm.DB) error {
+		var payment domain.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if payment.PaymentStatus != domain.PaymentStatusFailed && payment.PaymentStatus != domain.PaymentStatusVoided {
+			return &domain.PaymentDeleteError{Status: payment.PaymentStatus}
+		}
+
+		if err := tx.Where("payment_id = ?", payment.ID).Delete(&domain.Refund{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("payment_id = ?", payment.ID).Delete(&domain.PaymentEvent{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&payment).Error
+	})
 }
 
 func (repo *PaymentRepository) SearchPaymentsByUserID(userID string) ([]domain.Payment, error) {
@@ -51,8 +247,114 @@ func (repo *PaymentRepository) SearchPaymentsByOrderID(orderID string) ([]domain
 	return payments, err
 }
 
+// TotalPaid sums the active payments made for the order, in the order's
+// currency.
+func (repo *PaymentRepository) TotalPaid(orderID uint) (int64, error) {
+	return totalPaid(repo.DB, orderID)
+}
+
+func totalPaid(tx *gorm.DB, orderID uint) (int64, error) {
+	var total int64
+	err := tx.Model(&domain.Payment{}).
+		Select("COALESCE(SUM(amount_amount), 0)").
+		Where("order_id = ? AND status IN ?", orderID, domain.ActivePaymentStatuses).
+		Scan(&total).Error
+	return total, err
+}
+
+// StalePendingPayments returns up to limit payments that have been pending
+// since before cutoff, oldest first.
+func (repo *PaymentRepository) StalePendingPayments(cutoff time.Time, limit int) ([]domain.Payment, error) {
+	var payments []domain.Payment
+	err := repo.DB.Where("status = ? AND payment_date < ?", domain.PaymentStatusPending, cutoff).
+		Order("payment_date, id").
+		Limit(limit).
+		Find(&payments).Error
+	return payments, err
+}
+
 func (repo *PaymentRepository) SearchPaymentsByStatus(status string) ([]domain.Payment, error) {
 	var payments []domain.Payment
 	err := repo.DB.Where("status = ?", status).Find(&payments).Error
 	return payments, err
 }
+
+// ExportPayments streams the payments matching the filter, joined with their
+// user, in the order they were made.
+func (repo *PaymentRepository) ExportPayments(filter *domain.PaymentFilter, fn func(*domain.PaymentExportRow) error) error {
+	query := repo.DB.Table("payments").
+		Select(`payments.id AS payment_id, payments.payment_date, payments.status, payments.order_id, payments.user_id,
+			COALESCE(users.name, '') AS user_name, COALESCE(users.email, '') AS user_email,
+			payments.amount_amount, payments.amount_currency, payments.transaction_id`).
+		Joins("LEFT JOIN users ON users.id = payments.user_id")
+
+	if filter.UserID != nil {
+		query = query.Where("payments.user_id = ?", *filter.UserID)
+	}
+	if filter.OrderID != nil {
+		query = query.Where("payments.order_id = ?", *filter.OrderID)
+	}
+	if filter.From != nil {
+		query = query.Where("payments.payment_date >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("payments.payment_date < ?", *filter.To)
+	}
+	return streamRows(query.Order("payments.id"), fn)
+}
+
+// transitionPayment locks the payment row, applies the status change and
+// records it.
+func transitionPayment(tx *gorm.DB, payment *domain.Payment, status, reason string) error {
+	var current domain.Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", payment.ID).First(&current).Error; err != nil {
+		return err
+	}
+	if !domain.CanTransitionPayment(current.PaymentStatus, status) {
+		return &domain.InvalidPaymentTransitionError{From: current.PaymentStatus, To: status}
+	}
+
+	updates := map[string]interface{}{"status": status, "transaction_id": payment.TransactionID}
+	if status == domain.PaymentStatusFailed {
+		updates["failure_reason"] = reason
+	}
+	if err := tx.Model(&domain.Payment{}).Where("id = ?", payment.ID).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	event := domain.PaymentEvent{
+		PaymentID:  payment.ID,
+		FromStatus: current.PaymentStatus,
+		ToStatus:   status,
+		Reason:     reason,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return err
+	}
+
+	payment.PaymentStatus = status
+	if status == domain.PaymentStatusFailed {
+		payment.FailureReason = reason
+	}
+	if status != domain.PaymentStatusCaptured {
+		return nil
+	}
+
+	var order domain.Order
+	if err := lockOrder(tx, payment.OrderID, &order); err != nil {
+		return err
+	}
+	if order.Status != domain.OrderStatusPendingPayment {
+		return nil
+	}
+	return transitionOrder(tx, &order, domain.OrderStatusPaid, "system:payments", fmt.Sprintf("payment %d captured", payment.ID))
+}
+
+func refundableAmount(tx *gorm.DB, payment *domain.Payment) (domain.Money, error) {
+	var reserved int64
+	err := tx.Model(&domain.Refund{}).
+		Select("COALESCE(SUM(amount_amount), 0)").
+		Where("payment_id = ? AND status IN ?", payment.ID, []string{domain.RefundStatusPending, domain.RefundStatusCompleted}).
+		Scan(&reserved).Error
+	return payment.Amount.Sub(domain.NewMoney(reserved, payment.Amount.Currency)), err
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"e-commerce/internal/domain"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProductRepository struct {
@@ -29,8 +30,63 @@ func (pr *ProductRepository) GetProductByID(id string) (*domain.Product, error)
 	return &product, err
 }
 
-func (pr *ProductRepository) UpdateProduct(id string, updatedProduct *domain.Product) error {
-	return pr.DB.Model(&domain.Product{}).Where("id = ?", id).Updates(updatedProduct).Error
+// UpdateProduct sets the given columns of the product from updatedProduct.
+// Stock added by the update goes to waiting backorders first, oldest first.
+func (pr *ProductRepository) UpdateProduct(id string, updatedProduct *domain.Product, columns []string) error {
+	return pr.DB.Transaction(func(tx *gorm.DB) error {
+		var product domain.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if len(columns) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&product).Select(columns).Updates(updatedProduct).Error; err != nil {
+			return err
+		}
+
+		if err := tx.First(&product, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return allocateBackorders(tx, &product)
+	})
+}
+
+// allocateBackorders moves the product's stock to the backordered items of
+// open orders in the order they were placed, until either runs out.
+func allocateBackorders(tx *gorm.DB, product *domain.Product) error {
+	if product.Quantity <= 0 || product.Backordered <= 0 {
+		return nil
+	}
+
+	var items []domain.OrderItem
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "order_items"}}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.product_id = ? AND order_items.backordered > 0", product.ID).
+		Where("orders.status NOT IN ?", []string{domain.OrderStatusCancelled, domain.OrderStatusRefunded}).
+		Order("order_items.id").
+		Find(&items).Error; err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if product.Quantity == 0 {
+			break
+		}
+		allocated := min(product.Quantity, item.Backordered)
+		if err := tx.Model(&domain.OrderItem{}).
+			Where("id = ?", item.ID).
+			Update("backordered", item.Backordered-allocated).Error; err != nil {
+			return err
+		}
+		product.Quantity -= allocated
+		product.Backordered -= allocated
+	}
+
+	return tx.Model(&domain.Product{}).
+		Where("id = ?", product.ID).
+		Updates(map[string]interface{}{"quantity": product.Quantity, "backordered": product.Backordered}).Error
 }
 
 func (pr *ProductRepository) DeleteProduct(id string) error {
@@ -3,6 +3,7 @@ package repository
 import (
 	"e-commerce/internal/domain"
 	"gorm.io/gorm"
+	"time"
 )
 
 type User interface {
@@ -18,19 +19,25 @@ type User interface {
 
 type Order interface {
 	SaveOrder(order *domain.Order) error
+	SaveCartOrder(order *domain.Order, cart *domain.Cart) error
 	GetOrderById(id uint) (*domain.Order, error)
 	GetAllOrders() ([]domain.Order, error)
 	UpdateOrder(id uint, updatedOrder *domain.Order) error
+	TransitionOrder(id uint, status, actor, reason string) (*domain.Order, error)
+	CancelOrder(id uint, actor, reason string) (*domain.Order, error)
+	ExpireOrders(cutoff time.Time, limit int, actor, reason string) ([]domain.Order, error)
+	GetOrderStatusHistory(id uint) ([]domain.OrderStatusHistory, error)
 	DeleteOrder(id uint) error
 	SearchOrdersByUserID(userID string) ([]domain.Order, error)
 	SearchOrdersByStatus(status string) ([]domain.Order, error)
+	FindOrders(filter *domain.OrderFilter) ([]domain.Order, error)
 }
 
 type Product interface {
 	SaveProduct(product *domain.Product) error
 	GetAllProducts() ([]domain.Product, error)
 	GetProductByID(id string) (*domain.Product, error)
-	UpdateProduct(id string, updatedProduct *domain.Product) error
+	UpdateProduct(id string, updatedProduct *domain.Product, columns []string) error
 	DeleteProduct(id string) error
 	SearchProductsByName(name string) ([]domain.Product, error)
 	SearchProductsByCategory(category string) ([]domain.Product, error)
@@ -40,25 +47,86 @@ type Payment interface {
 	GetAllPayments() ([]domain.Payment, error)
 	CreatePayment(payment *domain.Payment) error
 	GetPaymentByID(id string) (*domain.Payment, error)
-	UpdatePayment(payment *domain.Payment) error
 	DeletePayment(id string) error
 	SearchPaymentsByUserID(userID string) ([]domain.Payment, error)
 	SearchPaymentsByOrderID(orderID string) ([]domain.Payment, error)
 	SearchPaymentsByStatus(status string) ([]domain.Payment, error)
 }
 
+type Cart interface {
+	GetCartByUserID(userID uint) (*domain.Cart, error)
+	AddItem(userID, productID uint, quantity int) error
+	UpdateItemQuantity(userID, productID uint, quantity int) error
+	RemoveItem(userID, productID uint) error
+}
+
+type Coupon interface {
+	SaveCoupon(coupon *domain.Coupon) error
+	GetAllCoupons() ([]domain.Coupon, error)
+	GetCouponByCode(code string) (*domain.Coupon, error)
+	UpdateCoupon(code string, updatedCoupon *domain.Coupon) error
+	DeleteCoupon(code string) error
+	GetRedemptions(couponID uint) ([]domain.CouponRedemption, error)
+}
+
+type ExchangeRate interface {
+	SaveExchangeRate(rate *domain.ExchangeRate) error
+	GetAllExchangeRates() ([]domain.ExchangeRate, error)
+	GetExchangeRate(from, to string) (*domain.ExchangeRate, error)
+	DeleteExchangeRate(id string) error
+}
+
+type Shipping interface {
+	SaveShippingMethod(method *domain.ShippingMethod) error
+	GetAllShippingMethods() ([]domain.ShippingMethod, error)
+	GetShippingMethodByID(id uint) (*domain.ShippingMethod, error)
+	DeleteShippingMethod(id uint) error
+}
+
+type Shipment interface {
+	CreateShipment(orderID uint, request *domain.ShipmentRequest) (*domain.Shipment, error)
+	DeliverShipment(id uint, actor string) (*domain.Shipment, error)
+	GetShipmentByID(id uint) (*domain.Shipment, error)
+	GetShipmentsByOrderID(orderID uint) ([]domain.Shipment, error)
+}
+
+type Return interface {
+	CreateReturn(ret *domain.ReturnRequest) error
+	GetAllReturns() ([]domain.ReturnRequest, error)
+	GetReturnsByOrderID(orderID uint) ([]domain.ReturnRequest, error)
+	GetReturnByID(id uint) (*domain.ReturnRequest, error)
+	TransitionReturn(id uint, status, actor, reason string) (*domain.ReturnRequest, error)
+	ReceiveReturn(id uint, actor string, restock bool, reason string) (*domain.ReturnRequest, error)
+	StartRefund(id uint, actor, reason string) (*domain.ReturnRequest, error)
+	ReserveRefund(id uint, payment *domain.Payment) (*domain.Refund, error)
+	RefundReturn(id uint, actor, reason string) (*domain.ReturnRequest, error)
+	GetReturnStatusHistory(id uint) ([]domain.ReturnStatusHistory, error)
+}
+
 type Repository struct {
 	User
 	Order
 	Product
 	Payment
+	Cart
+	Coupon
+	ExchangeRate
+	Shipping
+	Shipment
+	Return
 }
 
 func NewRepository(db *gorm.DB) *Repository {
 	return &Repository{
-		User:    NewUserRepository(db),
-		Order:   NewOrderRepository(db),
-		Product: NewProductRepository(db),
-		Payment: NewPaymentRepository(db),
+		User:         NewUserRepository(db),
+		Order:        NewOrderRepository(db),
+		Product:      NewProductRepository(db),
+		Payment:      NewPaymentRepository(db),
+		Cart:         NewCartRepository(db),
+		Coupon:       NewCouponRepository(db),
+		ExchangeRate: NewExchangeRateRepository(db),
+		Shipping:     NewShippingRepository(db),
+		Shipment:     NewShipmentRepository(db),
+		Return:       NewReturnRepository(db),
 	}
 }
@@ -0,0 +1,349 @@
+package repository
+
+import (
+	"e-commerce/internal/domain"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReturnRepository struct {
+	DB *gorm.DB
+}
+
+func NewReturnRepository(db *gorm.DB) *ReturnRepository {
+	return &ReturnRepository{DB: db}
+}
+
+// CreateReturn opens a return against items of a completed order. Each item
+// is priced at what the customer paid for it and may not exceed the units
+// bought minus those already in other open or finished returns.
+func (rr *ReturnRepository) CreateReturn(ret *domain.ReturnRequest) error {
+	return rr.DB.Transaction(func(tx *gorm.DB) error {
+		var order domain.Order
+		if err := lockOrder(tx, ret.OrderID, &order); err != nil {
+			return err
+		}
+		if order.Status != domain.OrderStatusCompleted {
+			return &domain.ReturnError{Reason: "only completed orders can be returned"}
+		}
+		if err := tx.Where("order_id = ?", order.ID).Find(&order.TaxLines).Error; err != nil {
+			return err
+		}
+
+		returned, err := returnedQuantities(tx, order.ID, "return_requests.status <> ?", domain.ReturnStatusRejected)
+		if err != nil {
+			return err
+		}
+
+		ret.UserID = order.UserID
+		ret.Status = domain.ReturnStatusRequested
+		ret.RefundAmount = domain.NewMoney(0, order.Currency)
+		ret.Restocked = false
+		for i := range ret.Items {
+			item := &ret.Items[i]
+			line := findOrderItem(order.Items, item.ProductID)
+			if line == nil {
+				return &domain.ReturnError{Reason: fmt.Sprintf("product %d is not part of order %d", item.ProductID, order.ID)}
+			}
+			returned[item.ProductID] += item.Quantity
+			if returned[item.ProductID] > line.Quantity {
+				return &domain.ReturnError{Reason: fmt.Sprintf("cannot return more than %d units of product %d", line.Quantity, item.ProductID)}
+			}
+
+			item.RefundAmount = domain.RefundFor(&order, line, item.Quantity)
+			ret.RefundAmount = ret.RefundAmount.Add(item.RefundAmount)
+		}
+
+		if err := tx.Omit(clause.Associations).Create(ret).Error; err != nil {
+			return err
+		}
+		for i := range ret.Items {
+			ret.Items[i].ReturnRequestID = ret.ID
+		}
+		if err := tx.Create(&ret.Items).Error; err != nil {
+			return err
+		}
+
+		history := domain.ReturnStatusHistory{
+			ReturnRequestID: ret.ID,
+			ToStatus:        domain.ReturnStatusRequested,
+			Actor:           fmt.Sprintf("user:%d", ret.UserID),
+			Reason:          ret.Reason,
+		}
+		return tx.Create(&history).Error
+	})
+}
+
+func (rr *ReturnRepository) GetAllReturns() ([]domain.ReturnRequest, error) {
+	var returns []domain.ReturnRequest
+	err := rr.DB.Preload("Items").Order("id").Find(&returns).Error
+	return returns, err
+}
+
+func (rr *ReturnRepository) GetReturnsByOrderID(orderID uint) ([]domain.ReturnRequest, error) {
+	var returns []domain.ReturnRequest
+	err := rr.DB.Preload("Items").Where("order_id = ?", orderID).Order("id").Find(&returns).Error
+	return returns, err
+}
+
+func (rr *ReturnRepository) GetReturnByID(id uint) (*domain.ReturnRequest, error) {
+	var ret domain.ReturnRequest
+	if err := rr.DB.Preload("Items").Where("id = ?", id).First(&ret).Error; err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (rr *ReturnRepository) TransitionReturn(id uint, status, actor, reason string) (*domain.ReturnRequest, error) {
+	var ret domain.ReturnRequest
+	err := rr.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockReturn(tx, id, &ret); err != nil {
+			return err
+		}
+		return transitionReturn(tx, &ret, status, actor, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// ReceiveReturn records that the goods have arrived back and, if asked to,
+// puts the returned units back into stock.
+func (rr *ReturnRepository) ReceiveReturn(id uint, actor string, restock bool, reason string) (*domain.ReturnRequest, error) {
+	var ret domain.ReturnRequest
+	err := rr.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockReturn(tx, id, &ret); err != nil {
+			return err
+		}
+		if err := transitionReturn(tx, &ret, domain.ReturnStatusReceived, actor, reason); err != nil {
+			return err
+		}
+		if !restock {
+			return nil
+		}
+
+		for _, item := range ret.Items {
+			if err := tx.Model(&domain.Product{}).
+				Where("id = ?", item.ProductID).
+				Update("quantity", gorm.Expr("quantity + ?", item.Quantity)).Error; err != nil {
+				return err
+			}
+		}
+		ret.Restocked = true
+		return tx.Model(&domain.ReturnRequest{}).Where("id = ?", ret.ID).Update("restocked", true).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// StartRefund claims a received return for refunding. A return that is
+// already refunding is returned as is, so that refunds which failed part way
+// can be retried.
+func (rr *ReturnRepository) StartRefund(id uint, actor, reason string) (*domain.ReturnRequest, error) {
+	var ret domain.ReturnRequest
+	err := rr.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockReturn(tx, id, &ret); err != nil {
+			return err
+		}
+		if ret.Status == domain.ReturnStatusRefunding {
+			return nil
+		}
+		return transitionReturn(tx, &ret, domain.ReturnStatusRefunding, actor, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// ReserveRefund reserves a pending refund against the payment for what is
+// left of the return's refund amount, capped at what the payment can still
+// refund. The return row is locked so that concurrent or repeated refunds of
+// the return cannot exceed its amount. It returns nil when nothing is left
+// to refund from the payment.
+func (rr *ReturnRepository) ReserveRefund(id uint, payment *domain.Payment) (*domain.Refund, error) {
+	var refund *domain.Refund
+	err := rr.DB.Transaction(func(tx *gorm.DB) error {
+		var ret domain.ReturnRequest
+		if err := lockReturn(tx, id, &ret); err != nil {
+			return err
+		}
+		if ret.Status != domain.ReturnStatusRefunding {
+			return &domain.InvalidReturnTransitionError{From: ret.Status, To: domain.ReturnStatusRefunded}
+		}
+
+		remaining, err := returnRefundRemaining(tx, &ret)
+		if err != nil || remaining.Amount <= 0 {
+			return err
+		}
+
+		var current domain.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", payment.ID).First(&current).Error; err != nil {
+			return err
+		}
+		if current.PaymentStatus != domain.PaymentStatusCaptured && current.PaymentStatus != domain.PaymentStatusPartiallyRefunded {
+			return nil
+		}
+		refundable, err := refundableAmount(tx, &current)
+		if err != nil || refundable.Amount <= 0 || refundable.Currency != remaining.Currency {
+			return err
+		}
+
+		refund = &domain.Refund{
+			PaymentID:       payment.ID,
+			ReturnRequestID: &ret.ID,
+			Amount:          remaining.Min(refundable),
+			Reason:          fmt.Sprintf("return %d", ret.ID),
+			Status:          domain.RefundStatusPending,
+		}
+		return tx.Create(refund).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// RefundReturn marks a refunding return refunded once its refunds have
+// completed and cover its whole refund amount. A return whose payments could
+// not cover the amount stays refunding. Once every unit of the order has been
+// refunded through returns, the order itself moves to refunded.
+func (rr *ReturnRepository) RefundReturn(id uint, actor, reason string) (*domain.ReturnRequest, error) {
+	var ret domain.ReturnRequest
+	err := rr.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockReturn(tx, id, &ret); err != nil {
+			return err
+		}
+
+		var pending int64
+		if err := tx.Model(&domain.Refund{}).
+			Where("return_request_id = ? AND status = ?", ret.ID, domain.RefundStatusPending).
+			Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return &domain.ReturnError{Reason: "refunds of the return are still being processed"}
+		}
+
+		remaining, err := returnRefundRemaining(tx, &ret)
+		if err != nil {
+			return err
+		}
+		if remaining.Amount > 0 {
+			return &domain.ReturnError{Reason: remaining.String() + " of the return could not be refunded from the order's payments"}
+		}
+
+		var order domain.Order
+		if err := lockOrder(tx, ret.OrderID, &order); err != nil {
+			return err
+		}
+		if err := transitionReturn(tx, &ret, domain.ReturnStatusRefunded, actor, reason); err != nil {
+			return err
+		}
+
+		refunded, err := returnedQuantities(tx, order.ID, "return_requests.status = ?", domain.ReturnStatusRefunded)
+		if err != nil {
+			return err
+		}
+		for _, item := range order.Items {
+			if refunded[item.ProductID] < item.Quantity {
+				return nil
+			}
+		}
+
+		if order.Status == domain.OrderStatusRefunded {
+			return nil
+		}
+		return transitionOrder(tx, &order, domain.OrderStatusRefunded, actor, fmt.Sprintf("fully returned through return %d", ret.ID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (rr *ReturnRepository) GetReturnStatusHistory(id uint) ([]domain.ReturnStatusHistory, error) {
+	var history []domain.ReturnStatusHistory
+	if err := rr.DB.Where("return_request_id = ?", id).Order("created_at, id").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// returnedQuantities sums the returned units per product over the returns of
+// the order that match the status condition.
+func returnedQuantities(tx *gorm.DB, orderID uint, statusCondition, status string) (map[uint]int, error) {
+	var rows []struct {
+		ProductID uint
+		Quantity  int
+	}
+	err := tx.Model(&domain.ReturnItem{}).
+		Select("return_items.product_id, SUM(return_items.quantity) AS quantity").
+		Joins("JOIN return_requests ON return_requests.id = return_items.return_request_id").
+		Where("return_requests.order_id = ?", orderID).
+		Where(statusCondition, status).
+		Group("return_items.product_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	quantities := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		quantities[row.ProductID] = row.Quantity
+	}
+	return quantities, nil
+}
+
+// returnRefundRemaining is what is left of the return's refund amount after
+// its pending and completed refunds.
+func returnRefundRemaining(tx *gorm.DB, ret *domain.ReturnRequest) (domain.Money, error) {
+	var refunded int64
+	err := tx.Model(&domain.Refund{}).
+		Select("COALESCE(SUM(amount_amount), 0)").
+		Where("return_request_id = ? AND status IN ?", ret.ID, []string{domain.RefundStatusPending, domain.RefundStatusCompleted}).
+		Scan(&refunded).Error
+	return ret.RefundAmount.Sub(domain.NewMoney(refunded, ret.RefundAmount.Currency)), err
+}
+
+func findOrderItem(items []domain.OrderItem, productID uint) *domain.OrderItem {
+	for i := range items {
+		if items[i].ProductID == productID {
+			return &items[i]
+		}
+	}
+	return nil
+}
+
+func lockReturn(tx *gorm.DB, id uint, ret *domain.ReturnRequest) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").Where("id = ?", id).First(ret).Error
+}
+
+// transitionReturn must run inside a transaction that holds the return row lock.
+func transitionReturn(tx *gorm.DB, ret *domain.ReturnRequest, status, actor, reason string) error {
+	if !domain.CanTransitionReturn(ret.Status, status) {
+		return &domain.InvalidReturnTransitionError{From: ret.Status, To: status}
+	}
+
+	if err := tx.Model(&domain.ReturnRequest{}).Where("id = ?", ret.ID).Update("status", status).Error; err != nil {
+		return err
+	}
+
+	history := domain.ReturnStatusHistory{
+		ReturnRequestID: ret.ID,
+		FromStatus:      ret.Status,
+		ToStatus:        status,
+		Actor:           actor,
+		Reason:          reason,
+	}
+	if err := tx.Create(&history).Error; err != nil {
+		return err
+	}
+
+	ret.Status = status
+	return nil
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"e-commerce/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type ShipmentRepository struct {
+	DB *gorm.DB
+}
+
+func NewShipmentRepository(db *gorm.DB) *ShipmentRepository {
+	return &ShipmentRepository{DB: db}
+}
+
+// CreateShipment records a shipment of the requested items, or of every unit
+// not shipped yet, and adds them to the fulfilled quantities of the order
+// lines. The order moves to partially_fulfilled while units remain to be
+// shipped and to shipped once every line is fulfilled, passing through
+// processing if it has only been paid so far.
+func (sr *ShipmentRepository) CreateShipment(orderID uint, request *domain.ShipmentRequest) (*domain.Shipment, error) {
+	shipment := domain.Shipment{
+		OrderID:        orderID,
+		Carrier:        request.Carrier,
+		TrackingNumber: request.TrackingNumber,
+		Status:         domain.ShipmentStatusShipped,
+		ShippedAt:      time.Now(),
+	}
+
+	err := sr.DB.Transaction(func(tx *gorm.DB) error {
+		var order domain.Order
+		if err := lockOrder(tx, orderID, &order); err != nil {
+			return err
+		}
+
+		items, err := fulfilItems(&order, request.Items)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return &domain.InvalidTransitionError{From: order.Status, To: domain.OrderStatusShipped}
+		}
+		shipment.Items = items
+
+		reason := "shipped with " + request.Carrier + " " + request.TrackingNumber
+		if order.Status == domain.OrderStatusPaid {
+			if err := transitionOrder(tx, &order, domain.OrderStatusProcessing, request.Actor, reason); err != nil {
+				return err
+			}
+		}
+		status := domain.OrderStatusPartiallyFulfilled
+		if order.IsFulfilled() {
+			status = domain.OrderStatusShipped
+		}
+		if order.Status != status {
+			if err := transitionOrder(tx, &order, status, request.Actor, reason); err != nil {
+				return err
+			}
+		}
+
+		for _, item := range order.Items {
+			if err := tx.Model(&domain.OrderItem{}).
+				Where("id = ?", item.ID).
+				Update("fulfilled_quantity", item.FulfilledQuantity).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&shipment).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+// fulfilItems adds the requested units to the fulfilled quantities of the
+// order lines and returns them as shipment items. Backordered units cannot be
+// shipped, and with no request every unit in stock is shipped.
+func fulfilItems(order *domain.Order, requested []domain.ShipmentItem) ([]domain.ShipmentItem, error) {
+	lines := make(map[uint]*domain.OrderItem, len(order.Items))
+	for i := range order.Items {
+		lines[order.Items[i].ProductID] = &order.Items[i]
+	}
+
+	if len(requested) == 0 {
+		for i := range order.Items {
+			requested = append(requested, domain.ShipmentItem{ProductID: order.Items[i].ProductID, Quantity: order.Items[i].Shippable()})
+		}
+	}
+
+	var items []domain.ShipmentItem
+	index := make(map[uint]int)
+	for _, request := range requested {
+		if request.Quantity <= 0 {
+			continue
+		}
+
+		line, ok := lines[request.ProductID]
+		if !ok || request.Quantity > line.Shippable() {
+			remaining := 0
+			if ok {
+				remaining = line.Shippable()
+			}
+			return nil, &domain.FulfilmentError{ProductID: request.ProductID, Requested: request.Quantity, Remaining: remaining}
+		}
+		line.FulfilledQuantity += request.Quantity
+
+		if i, ok := index[line.ID]; ok {
+			items[i].Quantity += request.Quantity
+			continue
+		}
+		index[line.ID] = len(items)
+		items = append(items, domain.ShipmentItem{OrderItemID: line.ID, ProductID: line.ProductID, Quantity: request.Quantity})
+	}
+	return items, nil
+}
+
+// DeliverShipment marks the shipment delivered. Once all of the shipments of
+// a fully shipped order have arrived, the order moves to delivered and then
+// to completed. Delivering an already delivered shipment is a no-op.
+func (sr *ShipmentRepository) DeliverShipment(id uint, actor string) (*domain.Shipment, error) {
+	var shipment domain.Shipment
+	err := sr.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").Where("id = ?", id).First(&shipment).Error; err != nil {
+			return err
+		}
+		if shipment.Status == domain.ShipmentStatusDelivered {
+			return nil
+		}
+
+		var order domain.Order
+		if err := lockOrder(tx, shipment.OrderID, &order); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		shipment.Status = domain.ShipmentStatusDelivered
+		shipment.DeliveredAt = &now
+		if err := tx.Omit(clause.Associations).Save(&shipment).Error; err != nil {
+			return err
+		}
+
+		var pending int64
+		if err := tx.Model(&domain.Shipment{}).
+			Where("order_id = ? AND status <> ?", order.ID, domain.ShipmentStatusDelivered).
+			Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 || order.Status != domain.OrderStatusShipped {
+			return nil
+		}
+
+		if err := transitionOrder(tx, &order, domain.OrderStatusDelivered, actor, "all shipments delivered"); err != nil {
+			return err
+		}
+		return transitionOrder(tx, &order, domain.OrderStatusCompleted, actor, "all items delivered")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+func (sr *ShipmentRepository) GetShipmentByID(id uint) (*domain.Shipment, error) {
+	var shipment domain.Shipment
+	if err := sr.DB.Preload("Items").Where("id = ?", id).First(&shipment).Error; err != nil {
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+func (sr *ShipmentRepository) GetShipmentsByOrderID(orderID uint) ([]domain.Shipment, error) {
+	var shipments []domain.Shipment
+	err := sr.DB.Preload("Items").Where("order_id = ?", orderID).Order("id").Find(&shipments).Error
+	return shipments, err
+}
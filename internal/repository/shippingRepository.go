@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"e-commerce/internal/domain"
+	"gorm.io/gorm"
+)
+
+type ShippingRepository struct {
+	DB *gorm.DB
+}
+
+func NewShippingRepository(db *gorm.DB) *ShippingRepository {
+	return &ShippingRepository{DB: db}
+}
+
+func (sr *ShippingRepository) SaveShippingMethod(method *domain.ShippingMethod) error {
+	return sr.DB.Create(method).Error
+}
+
+func (sr *ShippingRepository) GetAllShippingMethods() ([]domain.ShippingMethod, error) {
+	var methods []domain.ShippingMethod
+	err := sr.DB.Preload("Rates").Order("id").Find(&methods).Error
+	return methods, err
+}
+
+func (sr *ShippingRepository) GetShippingMethodByID(id uint) (*domain.ShippingMethod, error) {
+	var method domain.ShippingMethod
+	if err := sr.DB.Preload("Rates").Where("id = ?", id).First(&method).Error; err != nil {
+		return nil, err
+	}
+	return &method, nil
+}
+
+func (sr *ShippingRepository) DeleteShippingMethod(id uint) error {
+	return sr.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("shipping_method_id = ?", id).Delete(&domain.ShippingRate{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&domain.ShippingMethod{}).Error
+	})
+}
@@ -0,0 +1,25 @@
+package repository
+
+import "gorm.io/gorm"
+
+// streamRows runs the query and hands each row to fn as it is read, so that
+// large results never have to fit in memory.
+func streamRows[T any](query *gorm.DB, fn func(*T) error) error {
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	db := query.Session(&gorm.Session{NewDB: true})
+	for rows.Next() {
+		var row T
+		if err := db.ScanRows(rows, &row); err != nil {
+			return err
+		}
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
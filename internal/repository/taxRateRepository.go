@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"e-commerce/internal/domain"
+	"gorm.io/gorm"
+)
+
+type TaxRateRepository struct {
+	DB *gorm.DB
+}
+
+func NewTaxRateRepository(db *gorm.DB) *TaxRateRepository {
+	return &TaxRateRepository{DB: db}
+}
+
+func (tr *TaxRateRepository) SaveTaxRate(rate *domain.TaxRate) error {
+	return tr.DB.Create(rate).Error
+}
+
+func (tr *TaxRateRepository) GetAllTaxRates() ([]domain.TaxRate, error) {
+	var rates []domain.TaxRate
+	err := tr.DB.Order("country, region, category").Find(&rates).Error
+	return rates, err
+}
+
+func (tr *TaxRateRepository) GetTaxRatesByCountry(country string) ([]domain.TaxRate, error) {
+	var rates []domain.TaxRate
+	err := tr.DB.Where("country = ?", country).Find(&rates).Error
+	return rates, err
+}
+
+func (tr *TaxRateRepository) DeleteTaxRate(id string) error {
+	return tr.DB.Delete(&domain.TaxRate{}, "id = ?", id).Error
+}
@@ -70,3 +70,22 @@ func (repo *UserRepository) SearchUsersByEmail(email string) ([]domain.User, err
 	}
 	return users, nil
 }
+
+// ExportUsers streams the users matching the filter with their order counts.
+// Names match like the name search, emails exactly.
+func (repo *UserRepository) ExportUsers(filter *domain.UserFilter, fn func(*domain.UserExportRow) error) error {
+	query := repo.DB.Table("users").
+		Select(`users.id AS user_id, users.name, users.email, users.address, users.role, users.registration_date,
+			(SELECT COUNT(*) FROM orders WHERE orders.user_id = users.id) AS order_count`)
+
+	if filter.Name != "" {
+		query = query.Where("users.name LIKE ?", "%"+filter.Name+"%")
+	}
+	if filter.Email != "" {
+		query = query.Where("users.email = ?", filter.Email)
+	}
+	if filter.Role != "" {
+		query = query.Where("users.role = ?", filter.Role)
+	}
+	return streamRows(query.Order("users.id"), fn)
+}
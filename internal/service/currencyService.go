@@ -0,0 +1,59 @@
+package service
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"errors"
+	"gorm.io/gorm"
+)
+
+// CurrencyConverter converts amounts with the rates stored in the
+// exchange_rates table. A pair can be stored in either direction.
+type CurrencyConverter struct {
+	ExchangeRateRepo *repository.ExchangeRateRepository
+}
+
+func NewCurrencyConverter(er *repository.ExchangeRateRepository) *CurrencyConverter {
+	return &CurrencyConverter{ExchangeRateRepo: er}
+}
+
+// Rate returns the price of one major unit of from in to.
+func (cc *CurrencyConverter) Rate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	rate, err := cc.ExchangeRateRepo.GetExchangeRate(from, to)
+	if err == nil {
+		return rate.Rate, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	inverse, err := cc.ExchangeRateRepo.GetExchangeRate(to, from)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, &domain.ExchangeRateError{From: from, To: to}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 1 / inverse.Rate, nil
+}
+
+// Convert returns the amount in the given currency. Amounts without a
+// currency are taken to be in the base currency.
+func (cc *CurrencyConverter) Convert(amount domain.Money, currency string) (domain.Money, error) {
+	if amount.Currency == "" {
+		amount.Currency = domain.BaseCurrency
+	}
+	if amount.Currency == currency {
+		return amount, nil
+	}
+
+	rate, err := cc.Rate(amount.Currency, currency)
+	if err != nil {
+		return domain.Money{}, err
+	}
+	return amount.Convert(currency, rate), nil
+}
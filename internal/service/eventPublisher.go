@@ -0,0 +1,25 @@
+package service
+
+import (
+	"e-commerce/internal/domain"
+	"encoding/json"
+	"log"
+)
+
+// EventPublisher delivers order events to whoever needs to react to them.
+type EventPublisher interface {
+	Publish(event domain.OrderEvent) error
+}
+
+// LogPublisher writes events to the application log. It stands in until the
+// service is connected to a message broker.
+type LogPublisher struct{}
+
+func (LogPublisher) Publish(event domain.OrderEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	log.Printf("Order event: %s\n", payload)
+	return nil
+}
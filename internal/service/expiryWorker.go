@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"log"
+	"time"
+)
+
+const (
+	orderExpiryActor     = "system:expiry"
+	orderExpiryReason    = "payment not received in time"
+	orderExpiryBatchSize = 100
+)
+
+// OrderExpiryWorker periodically cancels orders that were not paid within
+// TTL of being placed, returning their stock.
+type OrderExpiryWorker struct {
+	OrderRepo *repository.OrderRepository
+	Publisher EventPublisher
+	TTL       time.Duration
+	Interval  time.Duration
+}
+
+func NewOrderExpiryWorker(or *repository.OrderRepository, publisher EventPublisher, ttl, interval time.Duration) *OrderExpiryWorker {
+	return &OrderExpiryWorker{OrderRepo: or, Publisher: publisher, TTL: ttl, Interval: interval}
+}
+
+// Start runs the worker until ctx is cancelled.
+func (w *OrderExpiryWorker) Start(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if expired, err := w.ExpireOrders(time.Now()); err != nil {
+			log.Printf("Failed to expire unpaid orders: %v\n", err)
+		} else if len(expired) > 0 {
+			log.Printf("Expired %d unpaid order(s)\n", len(expired))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireOrders cancels every order still awaiting payment TTL after now and
+// publishes an event for each one. Failing to publish does not undo the
+// cancellation.
+func (w *OrderExpiryWorker) ExpireOrders(now time.Time) ([]domain.Order, error) {
+	var expired []domain.Order
+	for {
+		orders, err := w.OrderRepo.ExpireOrders(now.Add(-w.TTL), orderExpiryBatchSize, orderExpiryActor, orderExpiryReason)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, order := range orders {
+			event := domain.OrderEvent{
+				Type:       domain.OrderEventExpired,
+				OrderID:    order.ID,
+				UserID:     order.UserID,
+				Status:     order.Status,
+				Reason:     orderExpiryReason,
+				OccurredAt: now,
+			}
+			if err := w.Publisher.Publish(event); err != nil {
+				log.Printf("Failed to publish expiry of order %d: %v\n", order.ID, err)
+			}
+		}
+		expired = append(expired, orders...)
+
+		if len(orders) < orderExpiryBatchSize {
+			return expired, nil
+		}
+	}
+}
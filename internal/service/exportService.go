@@ -0,0 +1,124 @@
+package service
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+)
+
+// exportFlushInterval is the number of rows buffered before they are pushed
+// to the client.
+const exportFlushInterval = 500
+
+// ExportWriter writes export rows as CSV, with a header line, or as
+// newline-delimited JSON.
+type ExportWriter struct {
+	w    io.Writer
+	csv  *csv.Writer
+	json *json.Encoder
+	rows int
+}
+
+func NewExportWriter(w io.Writer, format string, header []string) (*ExportWriter, error) {
+	ew := &ExportWriter{w: w}
+	switch format {
+	case domain.ExportFormatCSV:
+		ew.csv = csv.NewWriter(w)
+		if err := ew.csv.Write(header); err != nil {
+			return nil, err
+		}
+	case domain.ExportFormatNDJSON:
+		ew.json = json.NewEncoder(w)
+	default:
+		return nil, domain.ErrExportFormat
+	}
+	return ew, nil
+}
+
+func (ew *ExportWriter) Write(row domain.ExportRow) error {
+	var err error
+	if ew.csv != nil {
+		err = ew.csv.Write(row.CSVRecord())
+	} else {
+		err = ew.json.Encode(row)
+	}
+	if err != nil {
+		return err
+	}
+
+	ew.rows++
+	if ew.rows%exportFlushInterval == 0 {
+		return ew.Flush()
+	}
+	return nil
+}
+
+// Flush pushes buffered rows to the underlying writer, and on to the client
+// when it is an HTTP response.
+func (ew *ExportWriter) Flush() error {
+	if ew.csv != nil {
+		ew.csv.Flush()
+		if err := ew.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := ew.w.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Exporter streams orders, payments and users to a writer one row at a time.
+type Exporter struct {
+	OrderRepo   *repository.OrderRepository
+	PaymentRepo *repository.PaymentRepository
+	UserRepo    *repository.UserRepository
+}
+
+func NewExporter(or *repository.OrderRepository, pr *repository.PaymentRepository, ur *repository.UserRepository) *Exporter {
+	return &Exporter{OrderRepo: or, PaymentRepo: pr, UserRepo: ur}
+}
+
+func (e *Exporter) ExportOrders(w io.Writer, format string, filter *domain.OrderFilter) error {
+	ew, err := NewExportWriter(w, format, domain.OrderExportHeader)
+	if err != nil {
+		return err
+	}
+	err = e.OrderRepo.ExportOrders(filter, func(row *domain.OrderExportRow) error {
+		return ew.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	return ew.Flush()
+}
+
+func (e *Exporter) ExportPayments(w io.Writer, format string, filter *domain.PaymentFilter) error {
+	ew, err := NewExportWriter(w, format, domain.PaymentExportHeader)
+	if err != nil {
+		return err
+	}
+	err = e.PaymentRepo.ExportPayments(filter, func(row *domain.PaymentExportRow) error {
+		return ew.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	return ew.Flush()
+}
+
+func (e *Exporter) ExportUsers(w io.Writer, format string, filter *domain.UserFilter) error {
+	ew, err := NewExportWriter(w, format, domain.UserExportHeader)
+	if err != nil {
+		return err
+	}
+	err = e.UserRepo.ExportUsers(filter, func(row *domain.UserExportRow) error {
+		return ew.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	return ew.Flush()
+}
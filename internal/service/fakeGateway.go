@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"e-commerce/internal/domain"
+	"fmt"
+	"sync"
+)
+
+// FakeGateway is an in-memory PaymentGateway for local development and
+// tests. It approves every authorization unless Decline is set.
+type FakeGateway struct {
+	Decline bool
+
+	mu           sync.Mutex
+	next         int
+	transactions map[string]*fakeTransaction
+}
+
+type fakeTransaction struct {
+	PaymentResult
+	refunded int64
+}
+
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{transactions: make(map[string]*fakeTransaction)}
+}
+
+func (g *FakeGateway) Authorize(ctx context.Context, request *PaymentRequest) (*PaymentResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.Decline {
+		return nil, &domain.PaymentGatewayError{Operation: "authorize", Reason: "card declined"}
+	}
+
+	g.next++
+	transaction := &fakeTransaction{PaymentResult: PaymentResult{
+		TransactionID: fmt.Sprintf("fake-%d", g.next),
+		InvoiceID:     request.InvoiceID,
+		Status:        domain.PaymentStatusAuthorized,
+		Amount:        request.Amount,
+	}}
+	g.transactions[transaction.TransactionID] = transaction
+
+	result := transaction.PaymentResult
+	return &result, nil
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, transactionID string, amount domain.Money) error {
+	return g.move(transactionID, "capture", domain.PaymentStatusCaptured, domain.PaymentStatusAuthorized)
+}
+
+func (g *FakeGateway) Void(ctx context.Context, transactionID string) error {
+	return g.move(transactionID, "void", domain.PaymentStatusVoided, domain.PaymentStatusAuthorized)
+}
+
+// Refund returns part or all of a captured amount, like epay does for
+// partial refunds.
+func (g *FakeGateway) Refund(ctx context.Context, transactionID string, amount domain.Money) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	transaction, err := g.find(transactionID, "refund", domain.PaymentStatusCaptured, domain.PaymentStatusPartiallyRefunded)
+	if err != nil {
+		return err
+	}
+	if transaction.refunded+amount.Amount > transaction.Amount.Amount {
+		return &domain.PaymentGatewayError{Operation: "refund", Reason: "amount exceeds the captured amount"}
+	}
+
+	transaction.refunded += amount.Amount
+	transaction.Status = domain.PaymentStatusPartiallyRefunded
+	if transaction.refunded == transaction.Amount.Amount {
+		transaction.Status = domain.PaymentStatusRefunded
+	}
+	return nil
+}
+
+func (g *FakeGateway) GetStatus(ctx context.Context, invoiceID string) (*PaymentResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, transaction := range g.transactions {
+		if transaction.InvoiceID == invoiceID {
+			result := transaction.PaymentResult
+			return &result, nil
+		}
+	}
+	return nil, &domain.PaymentGatewayError{Operation: "status", Reason: "unknown invoice " + invoiceID}
+}
+
+func (g *FakeGateway) move(transactionID, operation, to string, from ...string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	transaction, err := g.find(transactionID, operation, from...)
+	if err != nil {
+		return err
+	}
+	transaction.Status = to
+	return nil
+}
+
+// find looks a transaction up for an operation, failing like a real
+// provider would when it is unknown or not in one of the expected states.
+// The caller must hold the lock.
+func (g *FakeGateway) find(transactionID, operation string, from ...string) (*fakeTransaction, error) {
+	transaction, ok := g.transactions[transactionID]
+	if !ok {
+		return nil, &domain.PaymentGatewayError{Operation: operation, Reason: "unknown transaction " + transactionID}
+	}
+	for _, status := range from {
+		if transaction.Status == status {
+			return transaction, nil
+		}
+	}
+	return nil, &domain.PaymentGatewayError{Operation: operation, Reason: "transaction is " + transaction.Status}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"e-commerce/internal/domain"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	HomebankCallbackPath        = "/payments/callbacks/homebank"
+	HomebankFailureCallbackPath = "/payments/callbacks/homebank/failure"
+
+	// homebankPlaceholderSecret is the example callback secret that used to
+	// be committed with the project. It is public, so it is never accepted.
+	homebankPlaceholderSecret = "change-me"
+)
+
+// HomebankSigner signs invoices with the callback secret. The signature is
+// sent to epay as secret_hash and echoed back in postLink callbacks, which
+// proves that a callback is about a payment we started.
+type HomebankSigner struct {
+	Secret []byte
+}
+
+func NewHomebankSigner(secret string) *HomebankSigner {
+	return &HomebankSigner{Secret: []byte(secret)}
+}
+
+func (s *HomebankSigner) Sign(invoiceID string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(invoiceID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Validate reports an error when the secret is missing or is the public
+// placeholder, either of which would let anyone forge callbacks.
+func (s *HomebankSigner) Validate() error {
+	switch strings.TrimSpace(string(s.Secret)) {
+	case "":
+		return errors.New("callback secret is not set")
+	case homebankPlaceholderSecret:
+		return errors.New("callback secret is the public placeholder")
+	}
+	return nil
+}
+
+// Verify reports whether hash is the signature of the invoice. Nothing
+// verifies without a secret.
+func (s *HomebankSigner) Verify(invoiceID, hash string) bool {
+	if len(s.Secret) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(s.Sign(invoiceID)), []byte(hash))
+}
+
+// HomebankCallback is the payment result epay posts to postLink and
+// failurePostLink.
+type HomebankCallback struct {
+	ID         string      `json:"id"`
+	InvoiceID  string      `json:"invoiceId"`
+	Amount     json.Number `json:"amount"`
+	Currency   string      `json:"currency"`
+	Code       string      `json:"code"`
+	Reason     string      `json:"reason"`
+	ReasonCode json.Number `json:"reasonCode"`
+	SecretHash string      `json:"secret_hash"`
+}
+
+// Succeeded reports whether the callback confirms a charged payment.
+func (c *HomebankCallback) Succeeded() bool {
+	return strings.EqualFold(c.Code, "ok")
+}
+
+// Money returns the amount the callback reports.
+func (c *HomebankCallback) Money() (domain.Money, error) {
+	return domain.ParseMoney(c.Amount.String(), strings.ToUpper(c.Currency))
+}
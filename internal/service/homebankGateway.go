@@ -0,0 +1,389 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"e-commerce/internal/domain"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ClientID     = "test"
+	ClientSecret = "yF587AV9Ms94qN2QShFzVR3vFnWkhjbAK3sG"
+
+	homebankTokenURL     = "https://testoauth.homebank.kz/epay2/oauth2/token"
+	homebankAPIURL       = "https://testepay.homebank.kz/api"
+	homebankPublicKeyURL = "https://testepay.homebank.kz/api/public.rsa"
+
+	homebankTimeout = 30 * time.Second
+)
+
+// HomebankConfig holds the merchant settings for epay. TerminalID is the
+// merchant terminal card payments go to, and CallbackURL is the
+// public base URL of this service, which epay posts payment results to. The
+// card encryption key is read from PublicKeyFile when it is set and fetched
+// from PublicKeyURL otherwise, and reloaded every PublicKeyRefresh. Requests
+// to epay give up after Timeout.
+type HomebankConfig struct {
+	TerminalID       string
+	CallbackURL      string
+	Signer           *HomebankSigner
+	PublicKeyURL     string
+	PublicKeyFile    string
+	PublicKeyRefresh time.Duration
+	Timeout          time.Duration
+}
+
+// HomebankGateway is the PaymentGateway backed by the Homebank epay API.
+type HomebankGateway struct {
+	TokenURL     string
+	APIURL       string
+	ClientID     string
+	ClientSecret string
+	TerminalID   string
+	CallbackURL  string
+	Signer       *HomebankSigner
+	Tokens       *CachedTokenSource
+	PublicKey    *PublicKeySource
+	Client       *http.Client
+}
+
+func NewHomebankGateway(config HomebankConfig) *HomebankGateway {
+	g := &HomebankGateway{
+		TokenURL:     homebankTokenURL,
+		APIURL:       homebankAPIURL,
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		TerminalID:   config.TerminalID,
+		CallbackURL:  strings.TrimSuffix(config.CallbackURL, "/"),
+		Signer:       config.Signer,
+		Client:       &http.Client{Timeout: config.Timeout},
+	}
+	if g.Client.Timeout <= 0 {
+		g.Client.Timeout = homebankTimeout
+	}
+	g.Tokens = NewCachedTokenSource(g.fetchToken)
+
+	if config.PublicKeyURL == "" {
+		config.PublicKeyURL = homebankPublicKeyURL
+	}
+	if config.PublicKeyRefresh <= 0 {
+		config.PublicKeyRefresh = time.Hour
+	}
+	g.PublicKey = NewPublicKeySource(config.PublicKeyURL, config.PublicKeyFile, config.PublicKeyRefresh, g.Client)
+	return g
+}
+
+func (g *HomebankGateway) token() (string, error) {
+	return g.Tokens.Token()
+}
+
+// fetchToken requests a new client credentials token. Network errors and
+// 5xx or 429 responses are transient.
+func (g *HomebankGateway) fetchToken() (*Token, error) {
+	data := url.Values{}
+	data.Set("grant_type", "client_credentials")
+	data.Set("scope", "webapi usermanagement email_send verification statement statistics payment")
+	data.Set("client_id", g.ClientID)
+	data.Set("client_secret", g.ClientSecret)
+
+	req, err := http.NewRequest("POST", g.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	requestedAt := time.Now()
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, &TransientError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &TransientError{Err: err}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := errors.New("failed to get token, status code: " + resp.Status)
+		if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, &TransientError{Err: err}
+		}
+		return nil, err
+	}
+
+	var tokenResp TokenResponse
+	if err := json.NewDecoder(strings.NewReader(string(body))).Decode(&tokenResp); err != nil {
+		return nil, err
+	}
+
+	// A token without a usable lifetime is used once and not cached.
+	expiresIn, _ := tokenResp.ExpiresIn.Int64()
+	return &Token{
+		AccessToken: tokenResp.AccessToken,
+		ExpiresAt:   requestedAt.Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
+
+type TokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	TokenType   string      `json:"token_type"`
+	ExpiresIn   json.Number `json:"expires_in"`
+	Scope       string      `json:"scope"`
+}
+
+// Ready reports whether clients can be given a key to encrypt card data.
+func (g *HomebankGateway) Ready() error {
+	return g.PublicKey.Ready()
+}
+
+// CardEncryptionKey returns the terminal and public key clients build
+// cryptograms with.
+func (g *HomebankGateway) CardEncryptionKey() (*CardEncryptionKey, error) {
+	publicKey, err := g.PublicKey.PEM()
+	if err != nil {
+		return nil, err
+	}
+	return &CardEncryptionKey{TerminalID: g.TerminalID, PublicKey: string(publicKey)}, nil
+}
+
+type PaymentResponse struct {
+	ID        string      `json:"id"`
+	Status    string      `json:"status"`
+	Message   string      `json:"message"`
+	PaymentID string      `json:"payment_id"`
+	Amount    json.Number `json:"amount"`
+	Currency  string      `json:"currency"`
+	InvoiceID string      `json:"invoice_id"`
+}
+
+// Authorize pays the invoice with the client's cryptogram, or with a saved
+// card on the configured terminal when a card token is given.
+func (g *HomebankGateway) Authorize(ctx context.Context, request *PaymentRequest) (*PaymentResult, error) {
+	token, err := g.token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment token: %v", err)
+	}
+
+	requestData := map[string]interface{}{
+		"amount":          json.Number(request.Amount.Decimal()),
+		"currency":        request.Amount.Currency,
+		"name":            request.Name,
+		"invoiceId":       request.InvoiceID,
+		"description":     request.Description,
+		"accountId":       strconv.Itoa(int(request.CustomerID)),
+		"email":           request.Email,
+		"postLink":        g.CallbackURL + HomebankCallbackPath,
+		"failurePostLink": g.CallbackURL + HomebankFailureCallbackPath,
+		"secret_hash":     g.Signer.Sign(request.InvoiceID),
+	}
+	paymentURL := g.APIURL + "/payment/cryptopay"
+	if request.CardToken != "" {
+		paymentURL = g.APIURL + "/payments/cards/auth"
+		requestData["paymentType"] = "cardId"
+		requestData["cardId"] = map[string]string{"id": request.CardToken}
+		requestData["terminalId"] = g.TerminalID
+	} else {
+		requestData["cryptogram"] = request.Cryptogram
+		requestData["cardSave"] = true
+	}
+
+	requestBody, err := json.Marshal(requestData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize request data: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", paymentURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+	g.checkToken(resp, token)
+
+	var paymentResponse PaymentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&paymentResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := &domain.PaymentGatewayError{Operation: "authorize", Reason: fmt.Sprintf("status code %d %s", resp.StatusCode, paymentResponse.Message)}
+		if request.Cryptogram != "" && g.publicKeyChanged() {
+			return nil, &CardKeyChangedError{Err: err}
+		}
+		return nil, err
+	}
+
+	transactionID := paymentResponse.ID
+	if transactionID == "" {
+		transactionID = paymentResponse.PaymentID
+	}
+	return &PaymentResult{
+		TransactionID: transactionID,
+		InvoiceID:     request.InvoiceID,
+		Status:        homebankStatus(paymentResponse.Status),
+		Amount:        request.Amount,
+		Message:       paymentResponse.Message,
+	}, nil
+}
+
+type OperationResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Capture charges an authorized payment.
+func (g *HomebankGateway) Capture(ctx context.Context, transactionID string, amount domain.Money) error {
+	return g.operation(ctx, "charge", g.APIURL+"/operation/"+url.PathEscape(transactionID)+"/charge?amount="+amount.Decimal())
+}
+
+// Void cancels an authorized payment that has not been charged yet.
+func (g *HomebankGateway) Void(ctx context.Context, transactionID string) error {
+	return g.operation(ctx, "void", g.APIURL+"/operation/"+url.PathEscape(transactionID)+"/cancel")
+}
+
+// Refund returns the given amount of a charged payment to the card.
+func (g *HomebankGateway) Refund(ctx context.Context, transactionID string, amount domain.Money) error {
+	return g.operation(ctx, "refund", g.APIURL+"/operation/"+url.PathEscape(transactionID)+"/refund?amount="+amount.Decimal())
+}
+
+func (g *HomebankGateway) operation(ctx context.Context, name, operationURL string) error {
+	token, err := g.token()
+	if err != nil {
+		return fmt.Errorf("failed to get payment token: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", operationURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+	g.checkToken(resp, token)
+
+	if resp.StatusCode != http.StatusOK {
+		var operationResponse OperationResponse
+		_ = json.NewDecoder(resp.Body).Decode(&operationResponse)
+		return &domain.PaymentGatewayError{Operation: name, Reason: fmt.Sprintf("status code %d %s", resp.StatusCode, operationResponse.Message)}
+	}
+
+	return nil
+}
+
+type StatusResponse struct {
+	ResultCode    string `json:"resultCode"`
+	ResultMessage string `json:"resultMessage"`
+	Transaction   struct {
+		ID         string      `json:"id"`
+		InvoiceID  string      `json:"invoiceID"`
+		Amount     json.Number `json:"amount"`
+		Currency   string      `json:"currency"`
+		StatusName string      `json:"statusName"`
+	} `json:"transaction"`
+}
+
+// GetStatus asks epay for the latest state of the invoice's transaction.
+func (g *HomebankGateway) GetStatus(ctx context.Context, invoiceID string) (*PaymentResult, error) {
+	token, err := g.token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment token: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", g.APIURL+"/check-status/payment/transaction/"+url.PathEscape(invoiceID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+	g.checkToken(resp, token)
+
+	var statusResponse StatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&statusResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &domain.PaymentGatewayError{Operation: "status", Reason: fmt.Sprintf("status code %d %s", resp.StatusCode, statusResponse.ResultMessage)}
+	}
+
+	amount, err := domain.ParseMoney(statusResponse.Transaction.Amount.String(), statusResponse.Transaction.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse amount: %v", err)
+	}
+	return &PaymentResult{
+		TransactionID: statusResponse.Transaction.ID,
+		InvoiceID:     invoiceID,
+		Status:        homebankStatus(statusResponse.Transaction.StatusName),
+		Amount:        amount,
+		Message:       statusResponse.ResultMessage,
+	}, nil
+}
+
+// publicKeyChanged reloads the card encryption key after epay rejects a
+// cryptogram and reports whether the key has been rotated, in which case the
+// cryptogram was most likely built with the old key.
+func (g *HomebankGateway) publicKeyChanged() bool {
+	previous, err := g.PublicKey.Key()
+	if err != nil {
+		return false
+	}
+	if err := g.PublicKey.Reload(); err != nil {
+		return false
+	}
+	current, err := g.PublicKey.Key()
+	return err == nil && !current.Equal(previous)
+}
+
+// checkToken drops the cached token when epay rejects it, so that the next
+// call fetches a new one.
+func (g *HomebankGateway) checkToken(resp *http.Response, token string) {
+	if resp.StatusCode == http.StatusUnauthorized {
+		g.Tokens.Invalidate(token)
+	}
+}
+
+// homebankStatus maps epay transaction statuses onto payment statuses.
+// Transactions epay has not settled yet, such as NEW, and statuses this
+// service does not know are pending, so that the payment is resolved later
+// by a callback or the payment reconciler.
+func homebankStatus(status string) string {
+	switch strings.ToUpper(status) {
+	case "AUTH":
+		return domain.PaymentStatusAuthorized
+	case "CHARGE":
+		return domain.PaymentStatusCaptured
+	case "CANCEL", "CANCEL_OLD":
+		return domain.PaymentStatusVoided
+	case "REFUND":
+		return domain.PaymentStatusRefunded
+	case "REJECT", "FAILED":
+		return domain.PaymentStatusFailed
+	default:
+		return domain.PaymentStatusPending
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"e-commerce/internal/repository"
+	"log"
+	"time"
+)
+
+// IdempotencyKeyPurger periodically deletes expired idempotency keys.
+type IdempotencyKeyPurger struct {
+	Repo     *repository.IdempotencyRepository
+	Interval time.Duration
+}
+
+func NewIdempotencyKeyPurger(repo *repository.IdempotencyRepository, interval time.Duration) *IdempotencyKeyPurger {
+	return &IdempotencyKeyPurger{Repo: repo, Interval: interval}
+}
+
+// Start runs the purger until ctx is cancelled.
+func (p *IdempotencyKeyPurger) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := p.Repo.PurgeExpired(time.Now()); err != nil {
+			log.Printf("Failed to purge expired idempotency keys: %v\n", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d expired idempotency key(s)\n", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
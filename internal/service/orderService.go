@@ -0,0 +1,114 @@
+package service
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"errors"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type OrderService struct {
+	ProductRepo  *repository.ProductRepository
+	CouponRepo   *repository.CouponRepository
+	ShippingRepo *repository.ShippingRepository
+	Tax          TaxCalculator
+	Currency     *CurrencyConverter
+}
+
+func NewOrderService(pr *repository.ProductRepository, cr *repository.CouponRepository, sr *repository.ShippingRepository, tax TaxCalculator, currency *CurrencyConverter) *OrderService {
+	return &OrderService{ProductRepo: pr, CouponRepo: cr, ShippingRepo: sr, Tax: tax, Currency: currency}
+}
+
+// PriceOrder loads every product on the order and computes its totals from
+// the current catalog prices, the chosen shipping method, the order's coupon
+// code, if any, and the tax for its shipping address. Amounts are converted
+// into the order's currency, and the total is also kept in the base currency.
+func (s *OrderService) PriceOrder(order *domain.Order) error {
+	order.Currency = strings.ToUpper(order.Currency)
+	if order.Currency == "" {
+		order.Currency = domain.BaseCurrency
+	}
+
+	products := make(map[uint]*domain.Product, len(order.Items))
+	for _, item := range order.Items {
+		product, err := s.ProductRepo.GetProductByID(strconv.Itoa(int(item.ProductID)))
+		if err != nil {
+			return &domain.ProductNotFoundError{ProductID: item.ProductID}
+		}
+		if product.Price, err = s.Currency.Convert(product.Price, order.Currency); err != nil {
+			return err
+		}
+		products[item.ProductID] = product
+	}
+
+	if err := PriceLines(order, products); err != nil {
+		return err
+	}
+
+	var method *domain.ShippingMethod
+	if order.ShippingMethodID != nil {
+		var err error
+		method, err = s.ShippingRepo.GetShippingMethodByID(*order.ShippingMethodID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &domain.ShippingError{Reason: strconv.Itoa(int(*order.ShippingMethodID)) + " does not exist"}
+		}
+		if err != nil {
+			return err
+		}
+		for i := range method.Rates {
+			rate := &method.Rates[i]
+			if err := s.convertAll(order.Currency, &rate.MinOrderTotal, &rate.MaxOrderTotal, &rate.Price); err != nil {
+				return err
+			}
+		}
+	}
+	if err := PriceShipping(order, method, products); err != nil {
+		return err
+	}
+
+	order.CouponID = nil
+	order.CouponCode = strings.ToUpper(strings.TrimSpace(order.CouponCode))
+	if order.CouponCode != "" {
+		coupon, err := s.CouponRepo.GetCouponByCode(order.CouponCode)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &domain.CouponError{Code: order.CouponCode, Reason: "does not exist"}
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.convertAll(order.Currency, &coupon.Amount, &coupon.MinOrderValue); err != nil {
+			return err
+		}
+		if err := ApplyCoupon(order, coupon, products, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	taxLines, err := s.Tax.CalculateTax(order, products)
+	if err != nil {
+		return err
+	}
+	order.TaxLines = taxLines
+
+	SumTotals(order)
+
+	if order.ExchangeRate, err = s.Currency.Rate(order.Currency, domain.BaseCurrency); err != nil {
+		return err
+	}
+	order.BaseTotal = order.TotalPrice.Convert(domain.BaseCurrency, order.ExchangeRate)
+	return nil
+}
+
+func (s *OrderService) convertAll(currency string, amounts ...*domain.Money) error {
+	for _, amount := range amounts {
+		converted, err := s.Currency.Convert(*amount, currency)
+		if err != nil {
+			return err
+		}
+		*amount = converted
+	}
+	return nil
+}
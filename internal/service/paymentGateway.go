@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"e-commerce/internal/domain"
+	"fmt"
+	"log"
+)
+
+const (
+	PaymentGatewayHomebank = "homebank"
+	PaymentGatewayFake     = "fake"
+)
+
+// PaymentGateway moves money through a card payment provider. Authorize
+// holds the amount on the card, Capture charges a held amount, Void releases
+// it and Refund returns charged money to the card. Calls to the provider are
+// abandoned when ctx is done.
+type PaymentGateway interface {
+	Authorize(ctx context.Context, request *PaymentRequest) (*PaymentResult, error)
+	Capture(ctx context.Context, transactionID string, amount domain.Money) error
+	Refund(ctx context.Context, transactionID string, amount domain.Money) error
+	Void(ctx context.Context, transactionID string) error
+	// GetStatus looks a payment up by the invoice it was made for.
+	GetStatus(ctx context.Context, invoiceID string) (*PaymentResult, error)
+}
+
+// ReadinessChecker is implemented by gateways that need resources before
+// they can take payments.
+type ReadinessChecker interface {
+	Ready() error
+}
+
+// CardEncryption is implemented by gateways whose clients encrypt card data
+// into a cryptogram themselves.
+type CardEncryption interface {
+	CardEncryptionKey() (*CardEncryptionKey, error)
+}
+
+// CardKeyChangedError is returned when a cryptogram is rejected and the card
+// encryption key turns out to have changed since it was last loaded. The
+// client has to fetch the new key and encrypt the card again.
+type CardKeyChangedError struct {
+	Err error
+}
+
+func (e *CardKeyChangedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CardKeyChangedError) Unwrap() error {
+	return e.Err
+}
+
+// CardEncryptionKey is what a client needs to build a cryptogram: the
+// terminal that goes into it and the PEM encoded key it is encrypted with.
+type CardEncryptionKey struct {
+	TerminalID string `json:"terminal_id"`
+	PublicKey  string `json:"public_key"`
+}
+
+// PaymentRequest is a payment for an order made by a customer. The card is
+// either a cryptogram the client encrypted or the token of a saved card.
+type PaymentRequest struct {
+	OrderID     uint
+	InvoiceID   string
+	Amount      domain.Money
+	Description string
+	CustomerID  uint
+	Name        string
+	Email       string
+	Cryptogram  string
+	CardToken   string
+}
+
+// NewPaymentRequest builds the request for charging the payment to the card
+// against the order on behalf of its buyer.
+func NewPaymentRequest(order *domain.Order, user *domain.User, payment *domain.Payment, card *domain.PaymentCard) *PaymentRequest {
+	return &PaymentRequest{
+		OrderID:     order.ID,
+		InvoiceID:   payment.InvoiceID,
+		Amount:      payment.Amount,
+		Description: fmt.Sprintf("Order %d", order.ID),
+		CustomerID:  user.ID,
+		Name:        user.Name,
+		Email:       user.Email,
+		Cryptogram:  card.Cryptogram,
+		CardToken:   card.CardToken,
+	}
+}
+
+// PaymentResult is the provider's view of a payment. Status is one of the
+// domain payment statuses.
+type PaymentResult struct {
+	TransactionID string
+	InvoiceID     string
+	Status        string
+	Amount        domain.Money
+	Message       string
+}
+
+// NewPaymentGateway returns the gateway configured by name, Homebank when
+// the name is empty. Homebank needs a real callback secret.
+func NewPaymentGateway(name string, homebank HomebankConfig) (PaymentGateway, error) {
+	switch name {
+	case "", PaymentGatewayHomebank:
+		if homebank.Signer == nil {
+			return nil, fmt.Errorf("homebank: callback secret is not set")
+		}
+		if err := homebank.Signer.Validate(); err != nil {
+			return nil, fmt.Errorf("homebank: %v, set HOMEBANK_CALLBACK_SECRET", err)
+		}
+		gateway := NewHomebankGateway(homebank)
+		if err := gateway.PublicKey.Reload(); err != nil {
+			log.Printf("Failed to load Homebank public key: %v\n", err)
+		}
+		return gateway, nil
+	case PaymentGatewayFake:
+		return NewFakeGateway(), nil
+	default:
+		return nil, fmt.Errorf("unknown payment gateway %q", name)
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"errors"
+	"log"
+	"time"
+)
+
+const paymentReconcileBatchSize = 100
+
+// PaymentReconciler periodically settles payments that are still pending
+// Timeout after they were made, for example because the process stopped
+// between saving a payment and authorizing it, by asking the gateway where
+// they stand.
+type PaymentReconciler struct {
+	PaymentRepo *repository.PaymentRepository
+	Gateway     PaymentGateway
+	Timeout     time.Duration
+	Interval    time.Duration
+}
+
+func NewPaymentReconciler(pr *repository.PaymentRepository, gateway PaymentGateway, timeout, interval time.Duration) *PaymentReconciler {
+	return &PaymentReconciler{PaymentRepo: pr, Gateway: gateway, Timeout: timeout, Interval: interval}
+}
+
+// Start runs the reconciler until ctx is cancelled.
+func (r *PaymentReconciler) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if settled, err := r.Reconcile(ctx, time.Now()); err != nil {
+			log.Printf("Failed to reconcile pending payments: %v\n", err)
+		} else if settled > 0 {
+			log.Printf("Settled %d stale pending payment(s)\n", settled)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile settles the payments pending since Timeout before now and
+// returns how many it settled. A payment the gateway has authorized or
+// captured takes that status; one the gateway does not know, has failed or
+// still reports as pending after Timeout is failed. Payments the gateway
+// cannot be asked about are left for the next run.
+func (r *PaymentReconciler) Reconcile(ctx context.Context, now time.Time) (int, error) {
+	payments, err := r.PaymentRepo.StalePendingPayments(now.Add(-r.Timeout), paymentReconcileBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	settled := 0
+	for i := range payments {
+		payment := &payments[i]
+
+		status, reason := domain.PaymentStatusFailed, "payment timed out"
+		result, err := r.Gateway.GetStatus(ctx, payment.InvoiceID)
+		var gatewayErr *domain.PaymentGatewayError
+		switch {
+		case errors.As(err, &gatewayErr):
+			reason = "unknown to the gateway"
+		case err != nil:
+			log.Printf("Failed to get status of payment %d: %v\n", payment.ID, err)
+			continue
+		case result.Status == domain.PaymentStatusAuthorized || result.Status == domain.PaymentStatusCaptured:
+			status, reason = result.Status, "reconciled with the gateway"
+			payment.TransactionID = result.TransactionID
+		case result.Status != domain.PaymentStatusPending:
+			reason = "gateway reported " + result.Status
+		}
+
+		if err := r.PaymentRepo.TransitionPayment(payment, status, reason); err != nil {
+			log.Printf("Failed to settle payment %d: %v\n", payment.ID, err)
+			continue
+		}
+		settled++
+	}
+	return settled, nil
+}
@@ -1,178 +0,0 @@
-package service
-
-import (
-	"bytes"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/base64"
-	"encoding/json"
-	"encoding/pem"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"strings"
-)
-
-const (
-	ClientID     = "test"
-	ClientSecret = "yF587AV9Ms94qN2QShFzVR3vFnWkhjbAK3sG"
-)
-
-func GetPaymentToken() (string, error) {
-	tokenURL := "https://testoauth.homebank.kz/epay2/oauth2/token"
-
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
-	data.Set("scope", "webapi usermanagement email_send verification statement statistics payment")
-	data.Set("client_id", ClientID)
-	data.Set("client_secret", ClientSecret)
-
-	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", errors.New("failed to get token, status code: " + resp.Status)
-	}
-
-	var tokenResp TokenResponse
-	if err := json.NewDecoder(strings.NewReader(string(body))).Decode(&tokenResp); err != nil {
-		return "", err
-	}
-
-	return tokenResp.AccessToken, nil
-}
-
-type TokenResponse struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
-	ExpiresIn   string `json:"expires_in"`
-	Scope       string `json:"scope"`
-}
-
-func fetchPublicKey(url string) (*rsa.PublicKey, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	block, _ := pem.Decode(body)
-	if block == nil {
-		return nil, errors.New("failed to parse PEM block containing the public key")
-	}
-
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-	if err != nil {
-		return nil, err
-	}
-
-	rsaPublicKey, ok := pub.(*rsa.PublicKey)
-	if !ok {
-		return nil, errors.New("not an RSA public key")
-	}
-
-	return rsaPublicKey, nil
-}
-
-func EncryptData(data interface{}) (string, error) {
-	publicKeyURL := "https://testepay.homebank.kz/api/public.rsa"
-	publicKey, err := fetchPublicKey(publicKeyURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch public key: %v", err)
-	}
-
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal data: %v", err)
-	}
-
-	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, publicKey, jsonData)
-	if err != nil {
-		return "", fmt.Errorf("encryption failed: %v", err)
-	}
-
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
-}
-
-type PaymentResponse struct {
-	Status    string  `json:"status"`
-	Message   string  `json:"message"`
-	PaymentID string  `json:"payment_id"`
-	Amount    float64 `json:"amount"`
-	Currency  string  `json:"currency"`
-	InvoiceID string  `json:"invoice_id"`
-}
-
-func MakePayment(token, encryptedData string) (*PaymentResponse, error) {
-	paymentURL := "https://testepay.homebank.kz/api/payment/cryptopay"
-
-	requestData := map[string]interface{}{
-		"amount":          100,
-		"currency":        "KZT",
-		"name":            "JON JONSON",
-		"cryptogram":      encryptedData,
-		"invoiceId":       "000001",
-		"invoiceIdAlt":    "8564546",
-		"description":     "test payment",
-		"accountId":       "uuid000001",
-		"email":           "jj@example.com",
-		"phone":           "77777777777",
-		"cardSave":        true,
-		"data":            `{"statement":{"name":"Arman Ali","invoiceID":"80000016"}}`,
-		"postLink":        "https://testmerchant/order/1123",
-		"failurePostLink": "https://testmerchant/order/1123/fail",
-	}
-
-	requestBody, err := json.Marshal(requestData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to serialize request data: %v", err)
-	}
-
-	req, err := http.NewRequest("POST", paymentURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+token)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	var paymentResponse PaymentResponse
-	if err := json.NewDecoder(resp.Body).Decode(&paymentResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode JSON response: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	return &paymentResponse, nil
-}
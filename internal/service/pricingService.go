@@ -0,0 +1,152 @@
+package service
+
+import (
+	"e-commerce/internal/domain"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PriceLines fills unit prices and line totals from the catalog products,
+// whose prices must already be in the order's currency. Any price sent by
+// the client is overwritten.
+func PriceLines(order *domain.Order, products map[uint]*domain.Product) error {
+	for i := range order.Items {
+		item := &order.Items[i]
+		product, ok := products[item.ProductID]
+		if !ok {
+			return fmt.Errorf("product %d is not priced", item.ProductID)
+		}
+
+		item.UnitPrice = product.Price
+		item.LineTotal = product.Price.Times(item.Quantity)
+		item.Discount = domain.NewMoney(0, order.Currency)
+	}
+	return nil
+}
+
+// PriceShipping picks the cheapest rate of the method that matches the
+// order's destination, parcel weight and subtotal. Rates must already be in
+// the order's currency. Orders without a method are not charged for shipping.
+func PriceShipping(order *domain.Order, method *domain.ShippingMethod, products map[uint]*domain.Product) error {
+	order.ShippingTotal = domain.NewMoney(0, order.Currency)
+	order.ShippingDiscount = domain.NewMoney(0, order.Currency)
+	if method == nil {
+		return nil
+	}
+	if method.Disabled {
+		return &domain.ShippingError{Reason: method.Code + " is not available"}
+	}
+
+	var weight float64
+	subtotal := domain.NewMoney(0, order.Currency)
+	for _, item := range order.Items {
+		weight += products[item.ProductID].Weight * float64(item.Quantity)
+		subtotal = subtotal.Add(item.LineTotal)
+	}
+
+	var rate *domain.ShippingRate
+	for i := range method.Rates {
+		candidate := &method.Rates[i]
+		if !candidate.Matches(strings.ToUpper(order.ShippingCountry), weight, subtotal) {
+			continue
+		}
+		if rate == nil || candidate.Price.Amount < rate.Price.Amount {
+			rate = candidate
+		}
+	}
+	if rate == nil {
+		return &domain.ShippingError{Reason: method.Code + " does not deliver this order"}
+	}
+
+	order.ShippingTotal = rate.Price
+	return nil
+}
+
+// ApplyCoupon checks that the coupon can be used for the order and spreads
+// its discount over the eligible lines in proportion to their totals. The
+// coupon's amounts must already be in the order's currency. Usage limits are
+// enforced when the order is saved.
+func ApplyCoupon(order *domain.Order, coupon *domain.Coupon, products map[uint]*domain.Product, now time.Time) error {
+	switch {
+	case coupon.Disabled:
+		return &domain.CouponError{Code: coupon.Code, Reason: "is not active"}
+	case coupon.StartsAt != nil && now.Before(*coupon.StartsAt):
+		return &domain.CouponError{Code: coupon.Code, Reason: "is not valid yet"}
+	case coupon.ExpiresAt != nil && !now.Before(*coupon.ExpiresAt):
+		return &domain.CouponError{Code: coupon.Code, Reason: "has expired"}
+	case coupon.UsageLimit > 0 && coupon.UsageCount >= coupon.UsageLimit:
+		return &domain.CouponError{Code: coupon.Code, Reason: "has reached its usage limit"}
+	}
+
+	subtotal := domain.NewMoney(0, order.Currency)
+	eligible := domain.NewMoney(0, order.Currency)
+	var eligibleLines []int
+	for i, item := range order.Items {
+		subtotal = subtotal.Add(item.LineTotal)
+		if coupon.AppliesTo(products[item.ProductID]) {
+			eligible = eligible.Add(item.LineTotal)
+			eligibleLines = append(eligibleLines, i)
+		}
+	}
+
+	if subtotal.Amount < coupon.MinOrderValue.Amount {
+		return &domain.CouponError{Code: coupon.Code, Reason: "requires a minimum order value of " + coupon.MinOrderValue.String()}
+	}
+	if len(eligibleLines) == 0 {
+		return &domain.CouponError{Code: coupon.Code, Reason: "does not apply to any product in the order"}
+	}
+
+	order.CouponID = &coupon.ID
+	order.CouponCode = coupon.Code
+
+	discount := domain.NewMoney(0, order.Currency)
+	switch coupon.Type {
+	case domain.CouponTypeFreeShipping:
+		order.ShippingDiscount = order.ShippingTotal
+	case domain.CouponTypePercentage:
+		discount = eligible.Percent(min(coupon.Value, 100))
+	case domain.CouponTypeFixedAmount:
+		discount = eligible.Min(coupon.Amount)
+	}
+	if discount.IsZero() {
+		return nil
+	}
+
+	remaining := discount
+	for n, i := range eligibleLines {
+		item := &order.Items[i]
+		if n == len(eligibleLines)-1 {
+			item.Discount = remaining
+			break
+		}
+		item.Discount = discount.Scale(item.LineTotal.Amount, eligible.Amount)
+		remaining = remaining.Sub(item.Discount)
+	}
+	return nil
+}
+
+// SumTotals computes the order totals from its priced lines, shipping and tax
+// lines. Tax that is already included in the prices is reported but not added.
+func SumTotals(order *domain.Order) {
+	subtotal := domain.NewMoney(0, order.Currency)
+	discount := order.ShippingDiscount
+	for _, item := range order.Items {
+		subtotal = subtotal.Add(item.LineTotal)
+		discount = discount.Add(item.Discount)
+	}
+
+	tax := domain.NewMoney(0, order.Currency)
+	addedTax := domain.NewMoney(0, order.Currency)
+	for _, line := range order.TaxLines {
+		tax = tax.Add(line.Amount)
+		if !line.Inclusive {
+			addedTax = addedTax.Add(line.Amount)
+		}
+	}
+
+	order.Subtotal = subtotal
+	order.DiscountTotal = discount
+	order.TaxTotal = tax
+	order.TotalPrice = subtotal.Sub(discount).Add(order.ShippingTotal).Add(addedTax)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// PublicKeySource provides the RSA key card data is encrypted with. The key
+// is read from File when it is set and fetched from URL otherwise. A loaded
+// key is kept for Refresh; when loading it again fails, the previous key
+// stays in use.
+type PublicKeySource struct {
+	URL     string
+	File    string
+	Refresh time.Duration
+	Client  *http.Client
+
+	mu       sync.Mutex
+	key      *rsa.PublicKey
+	loadedAt time.Time
+	group    singleflight.Group
+}
+
+func NewPublicKeySource(url, file string, refresh time.Duration, client *http.Client) *PublicKeySource {
+	return &PublicKeySource{URL: url, File: file, Refresh: refresh, Client: client}
+}
+
+func (s *PublicKeySource) Key() (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	key, stale := s.key, time.Since(s.loadedAt) >= s.Refresh
+	s.mu.Unlock()
+
+	if key != nil && !stale {
+		return key, nil
+	}
+
+	if err := s.Reload(); err != nil {
+		if key != nil {
+			log.Printf("Failed to refresh provider public key, keeping the previous one: %v\n", err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.key, nil
+}
+
+// Reload loads the key again regardless of its age. Concurrent callers share
+// one load.
+func (s *PublicKeySource) Reload() error {
+	_, err, _ := s.group.Do("key", func() (interface{}, error) {
+		key, err := s.load()
+		if err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		s.key, s.loadedAt = key, time.Now()
+		s.mu.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+// PEM returns the current key PEM encoded, for clients that encrypt with it.
+func (s *PublicKeySource) PEM() ([]byte, error) {
+	key, err := s.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// Ready reports whether a valid key is available, trying to load one if
+// there is none yet.
+func (s *PublicKeySource) Ready() error {
+	_, err := s.Key()
+	return err
+}
+
+func (s *PublicKeySource) load() (*rsa.PublicKey, error) {
+	var data []byte
+	var err error
+	if s.File != "" {
+		data, err = os.ReadFile(s.File)
+	} else {
+		data, err = s.fetch()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the public key")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaPublicKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+
+	return rsaPublicKey, nil
+}
+
+func (s *PublicKeySource) fetch() ([]byte, error) {
+	resp, err := s.Client.Get(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"e-commerce/internal/domain"
+	"e-commerce/internal/repository"
+	"strings"
+)
+
+// TaxCalculator computes the tax lines for a priced order. Implementations
+// must not change the order; OrderService folds the lines into its totals.
+type TaxCalculator interface {
+	CalculateTax(order *domain.Order, products map[uint]*domain.Product) ([]domain.OrderTaxLine, error)
+}
+
+// TableTaxCalculator looks rates up in the tax_rates table by the order's
+// shipping country and region and the product category.
+type TableTaxCalculator struct {
+	TaxRateRepo      *repository.TaxRateRepository
+	PricesIncludeTax bool
+	DefaultCountry   string
+}
+
+func NewTableTaxCalculator(tr *repository.TaxRateRepository, pricesIncludeTax bool, defaultCountry string) *TableTaxCalculator {
+	return &TableTaxCalculator{TaxRateRepo: tr, PricesIncludeTax: pricesIncludeTax, DefaultCountry: strings.ToUpper(defaultCountry)}
+}
+
+func (t *TableTaxCalculator) CalculateTax(order *domain.Order, products map[uint]*domain.Product) ([]domain.OrderTaxLine, error) {
+	country := strings.ToUpper(order.ShippingCountry)
+	if country == "" {
+		country = t.DefaultCountry
+	}
+
+	rates, err := t.TaxRateRepo.GetTaxRatesByCountry(country)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []domain.OrderTaxLine
+	for _, item := range order.Items {
+		rate := matchTaxRate(rates, order.ShippingRegion, products[item.ProductID].Category)
+		if rate == nil {
+			continue
+		}
+
+		taxable := item.LineTotal.Sub(item.Discount)
+		amount := taxable.Percent(rate.Rate)
+		if t.PricesIncludeTax {
+			amount = taxable.Percent(rate.Rate * 100 / (100 + rate.Rate))
+		}
+
+		lines = append(lines, domain.OrderTaxLine{
+			ProductID:     item.ProductID,
+			Name:          rate.Name,
+			Country:       rate.Country,
+			Region:        rate.Region,
+			Rate:          rate.Rate,
+			TaxableAmount: taxable,
+			Amount:        amount,
+			Inclusive:     t.PricesIncludeTax,
+		})
+	}
+	return lines, nil
+}
+
+// matchTaxRate picks the most specific rate, preferring a region match over
+// a category match over the country-wide rate.
+func matchTaxRate(rates []domain.TaxRate, region, category string) *domain.TaxRate {
+	var best *domain.TaxRate
+	bestScore := -1
+	for i, rate := range rates {
+		score := 0
+		if rate.Region != "" {
+			if !strings.EqualFold(rate.Region, region) {
+				continue
+			}
+			score += 2
+		}
+		if rate.Category != "" {
+			if !strings.EqualFold(rate.Category, category) {
+				continue
+			}
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = &rates[i], score
+		}
+	}
+	return best
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Token is an OAuth access token and the time it stops being valid.
+type Token struct {
+	AccessToken string
+	ExpiresAt   time.Time
+}
+
+// TransientError marks a failure worth retrying, such as a network error or
+// a 5xx response.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// CachedTokenSource hands out an OAuth token until Leeway before it expires.
+// Callers that find no valid token share a single refresh, and transient
+// refresh failures are retried up to Retries times with doubling backoff.
+type CachedTokenSource struct {
+	Fetch   func() (*Token, error)
+	Leeway  time.Duration
+	Retries int
+	Backoff time.Duration
+
+	mu    sync.Mutex
+	token *Token
+	group singleflight.Group
+}
+
+func NewCachedTokenSource(fetch func() (*Token, error)) *CachedTokenSource {
+	return &CachedTokenSource{
+		Fetch:   fetch,
+		Leeway:  time.Minute,
+		Retries: 3,
+		Backoff: 200 * time.Millisecond,
+	}
+}
+
+func (s *CachedTokenSource) Token() (string, error) {
+	if token := s.cached(); token != "" {
+		return token, nil
+	}
+
+	value, err, _ := s.group.Do("token", func() (interface{}, error) {
+		if token := s.cached(); token != "" {
+			return token, nil
+		}
+
+		token, err := s.refresh()
+		if err != nil {
+			return "", err
+		}
+
+		s.mu.Lock()
+		s.token = token
+		s.mu.Unlock()
+		return token.AccessToken, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+// Invalidate drops the cached token if it is still accessToken, so that a
+// token the provider rejected is not handed out again.
+func (s *CachedTokenSource) Invalidate(accessToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.token.AccessToken == accessToken {
+		s.token = nil
+	}
+}
+
+func (s *CachedTokenSource) cached() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil || !time.Now().Add(s.Leeway).Before(s.token.ExpiresAt) {
+		return ""
+	}
+	return s.token.AccessToken
+}
+
+func (s *CachedTokenSource) refresh() (*Token, error) {
+	backoff := s.Backoff
+	for attempt := 0; ; attempt++ {
+		token, err := s.Fetch()
+
+		var transient *TransientError
+		if err == nil || attempt >= s.Retries || !errors.As(err, &transient) {
+			return token, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -2,10 +2,19 @@ package tests
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"e-commerce/internal/domain"
 	"e-commerce/internal/handler"
 	"e-commerce/internal/repository"
+	"e-commerce/internal/service"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
@@ -13,7 +22,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -47,6 +60,13 @@ func setupDB() *gorm.DB {
 	return db
 }
 
+func kzt(amount int64) domain.Money {
+	return domain.NewMoney(amount, domain.BaseCurrency)
+}
+
+// testCard stands in for a cryptogram built by a client.
+var testCard = domain.PaymentCard{Cryptogram: "dGVzdCBjcnlwdG9ncmFt"}
+
 func TestMain(m *testing.M) {
 	testDB = setupDB()
 	code := m.Run()
@@ -59,7 +79,7 @@ func TestCreateProduct(t *testing.T) {
 	product := domain.Product{
 		Name:        "Test Product",
 		Description: "Test Description",
-		Price:       10.0,
+		Price:       kzt(1000),
 		Category:    "Test Category",
 		Quantity:    5,
 	}
@@ -88,7 +108,7 @@ func TestGetAllProducts(t *testing.T) {
 	product := domain.Product{
 		Name:        "Test Product",
 		Description: "Test Description",
-		Price:       10.0,
+		Price:       kzt(1000),
 		Category:    "Test Category",
 		Quantity:    5,
 	}
@@ -134,7 +154,7 @@ func TestUpdateProduct(t *testing.T) {
 	updatedProduct := domain.Product{
 		Name:        "Updated Product",
 		Description: "Updated Description",
-		Price:       20.0,
+		Price:       kzt(2000),
 		Category:    "Updated Category",
 		Quantity:    10,
 	}
@@ -156,6 +176,87 @@ func TestUpdateProduct(t *testing.T) {
 	assert.Equal(t, "Product updated successfully!", response["message"])
 }
 
+func TestUpdateProductKeepsOmittedFields(t *testing.T) {
+	router := setupProductRouter()
+	product := domain.Product{
+		Name:           "Backorder Product",
+		Description:    "Test Description",
+		Price:          kzt(1000),
+		Category:       "Test Category",
+		Quantity:       5,
+		Weight:         2.5,
+		AllowBackorder: true,
+		MaxBackorder:   3,
+	}
+	testDB.Create(&product)
+
+	body, _ := json.Marshal(gin.H{
+		"name":        "Renamed Product",
+		"description": "Test Description",
+		"price":       kzt(1500),
+		"category":    "Test Category",
+		"quantity":    5,
+	})
+	req, _ := http.NewRequest("PUT", "/products/"+strconv.Itoa(int(product.ID)), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var updated domain.Product
+	testDB.First(&updated, product.ID)
+	assert.Equal(t, "Renamed Product", updated.Name)
+	assert.Equal(t, int64(1500), updated.Price.Amount)
+	assert.Equal(t, 2.5, updated.Weight)
+	assert.True(t, updated.AllowBackorder)
+	assert.Equal(t, 3, updated.MaxBackorder)
+
+	body, _ = json.Marshal(gin.H{
+		"name":        "Counter Product",
+		"description": "Test Description",
+		"price":       kzt(1000),
+		"category":    "Test Category",
+		"quantity":    5,
+		"backordered": 7,
+	})
+	req, _ = http.NewRequest("POST", "/products", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created domain.Product
+	testDB.Where("name = ?", "Counter Product").First(&created)
+	assert.Equal(t, 0, created.Backordered)
+}
+
+func TestUpdateProductPartialBody(t *testing.T) {
+	router := setupProductRouter()
+	product := setupTestProduct()
+
+	for _, quantity := range []int{10, 0} {
+		body, _ := json.Marshal(gin.H{"quantity": quantity})
+		req, _ := http.NewRequest("PUT", "/products/"+strconv.Itoa(int(product.ID)), bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var updated domain.Product
+		testDB.First(&updated, product.ID)
+		assert.Equal(t, quantity, updated.Quantity)
+		assert.Equal(t, product.Name, updated.Name)
+		assert.Equal(t, product.Price.Amount, updated.Price.Amount)
+	}
+
+	body, _ := json.Marshal(gin.H{"quantity": -1})
+	req, _ := http.NewRequest("PUT", "/products/"+strconv.Itoa(int(product.ID)), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func TestDeleteProduct(t *testing.T) {
 	router := setupProductRouter()
 	product := setupTestProduct()
@@ -214,7 +315,7 @@ func setupTestProduct() domain.Product {
 	product := domain.Product{
 		Name:        "Test Product",
 		Description: "Test Description",
-		Price:       10.0,
+		Price:       kzt(1000),
 		Category:    "Test Category",
 		Quantity:    5,
 	}
@@ -380,13 +481,13 @@ func setupTestDB() (*gorm.DB, func()) {
 		panic("failed to connect database")
 	}
 
-	err = db.AutoMigrate(&domain.Order{}, &domain.User{}, &domain.Product{})
+	err = db.AutoMigrate(&domain.Order{}, &domain.OrderItem{}, &domain.OrderStatusHistory{}, &domain.User{}, &domain.Product{}, &domain.Payment{}, &domain.PaymentEvent{}, &domain.Refund{}, &domain.Coupon{}, &domain.CouponRedemption{}, &domain.TaxRate{}, &domain.ExchangeRate{}, &domain.OrderTaxLine{}, &domain.ShippingMethod{}, &domain.ShippingRate{}, &domain.Shipment{}, &domain.ShipmentItem{}, &domain.ReturnRequest{}, &domain.ReturnItem{}, &domain.ReturnStatusHistory{}, &domain.IdempotencyKey{})
 	if err != nil {
 		return nil, nil
 	}
 
 	cleanup := func() {
-		err := db.Migrator().DropTable(&domain.Order{}, &domain.User{}, &domain.Product{})
+		err := db.Migrator().DropTable(&domain.IdempotencyKey{}, &domain.ReturnStatusHistory{}, &domain.ReturnItem{}, &domain.ReturnRequest{}, &domain.ShipmentItem{}, &domain.Shipment{}, &domain.ShippingRate{}, &domain.ShippingMethod{}, &domain.OrderTaxLine{}, &domain.ExchangeRate{}, &domain.TaxRate{}, &domain.CouponRedemption{}, &domain.Coupon{}, &domain.Refund{}, &domain.PaymentEvent{}, &domain.Payment{}, &domain.OrderStatusHistory{}, &domain.OrderItem{}, &domain.Order{}, &domain.User{}, &domain.Product{})
 		if err != nil {
 			return
 		}
@@ -395,25 +496,37 @@ func setupTestDB() (*gorm.DB, func()) {
 	return db, cleanup
 }
 
-func TestCreateOrder(t *testing.T) {
-	db, cleanup := setupTestDB()
-	defer cleanup()
-
+func setupOrderHandler(db *gorm.DB) *handler.OrderHandler {
 	orderRepo := repository.NewOrderRepository(db)
 	userRepo := repository.NewUserRepository(db)
 	productRepo := repository.NewProductRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	couponRepo := repository.NewCouponRepository(db)
+	tax := service.NewTableTaxCalculator(repository.NewTaxRateRepository(db), false, "KZ")
+
+	shippingRepo := repository.NewShippingRepository(db)
+
+	currency := service.NewCurrencyConverter(repository.NewExchangeRateRepository(db))
+
+	orders := service.NewOrderService(productRepo, couponRepo, shippingRepo, tax, currency)
+	return handler.NewOrderHandler(orderRepo, userRepo, paymentRepo, orders, service.NewFakeGateway())
+}
+
+func TestCreateOrder(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
 
-	orderHandler := handler.NewOrderHandler(orderRepo, userRepo, productRepo)
+	orderHandler := setupOrderHandler(db)
 
 	user := domain.User{ID: 1}
-	product := domain.Product{ID: 1}
+	product := domain.Product{ID: 1, Price: kzt(10000), Quantity: 5}
 	db.Create(&user)
 	db.Create(&product)
 
 	order := domain.Order{
 		UserID:     1,
 		ProductIDs: []uint{1},
-		TotalPrice: 100.0,
+		TotalPrice: kzt(10000),
 		Status:     "new",
 	}
 	body, _ := json.Marshal(order)
@@ -432,7 +545,7 @@ func TestCreateOrder(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
 	}
 
-	var response map[string]string
+	var response map[string]interface{}
 	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
 		t.Fatalf("failed to unmarshal response: %v", err)
 	}
@@ -447,17 +560,13 @@ func TestGetAllOrders(t *testing.T) {
 	db, cleanup := setupTestDB()
 	defer cleanup()
 
-	orderRepo := repository.NewOrderRepository(db)
-	userRepo := repository.NewUserRepository(db)
-	productRepo := repository.NewProductRepository(db)
-
-	orderHandler := handler.NewOrderHandler(orderRepo, userRepo, productRepo)
+	orderHandler := setupOrderHandler(db)
 
 	user := domain.User{ID: 1}
 	product := domain.Product{ID: 1}
 	orders := []domain.Order{
-		{ID: 1, UserID: 1, ProductIDs: []uint{1}, TotalPrice: 100.0, Status: "new"},
-		{ID: 2, UserID: 1, ProductIDs: []uint{1}, TotalPrice: 200.0, Status: "processing"},
+		{ID: 1, UserID: 1, ProductIDs: []uint{1}, TotalPrice: kzt(10000), Status: "new"},
+		{ID: 2, UserID: 1, ProductIDs: []uint{1}, TotalPrice: kzt(20000), Status: "processing"},
 	}
 	db.Create(&user)
 	db.Create(&product)
@@ -474,12 +583,2091 @@ func TestGetAllOrders(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 
-	var response []domain.Order
+	var response struct {
+		Orders     []domain.Order `json:"orders"`
+		NextCursor string         `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	if len(response.Orders) != 2 {
+		t.Errorf("handler returned unexpected number of orders: got %v want %v", len(response.Orders), 2)
+	}
+	assert.Empty(t, response.NextCursor)
+}
+
+func TestGetAllOrdersFiltersAndPaginates(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderHandler := setupOrderHandler(db)
+
+	router := gin.New()
+	router.GET("/orders", orderHandler.GetAllOrders)
+
+	db.Create(&[]domain.User{{ID: 1}, {ID: 2}})
+	db.Create(&[]domain.Product{{ID: 1}, {ID: 2}})
+	day := time.Date(2024, 7, 6, 12, 0, 0, 0, time.UTC)
+	orders := []domain.Order{
+		{ID: 1, UserID: 1, TotalPrice: kzt(1000), BaseTotal: kzt(1000), OrderDate: day, Status: domain.OrderStatusPaid, Items: []domain.OrderItem{{ProductID: 1, Quantity: 1}}},
+		{ID: 2, UserID: 1, TotalPrice: kzt(2000), BaseTotal: kzt(2000), OrderDate: day.Add(time.Hour), Status: domain.OrderStatusShipped, Items: []domain.OrderItem{{ProductID: 2, Quantity: 1}}},
+		{ID: 3, UserID: 1, TotalPrice: kzt(3000), BaseTotal: kzt(3000), OrderDate: day.Add(2 * time.Hour), Status: domain.OrderStatusPaid, Items: []domain.OrderItem{{ProductID: 1, Quantity: 1}}},
+		{ID: 4, UserID: 1, TotalPrice: kzt(4000), BaseTotal: kzt(4000), OrderDate: day.AddDate(0, 0, 1), Status: domain.OrderStatusPaid, Items: []domain.OrderItem{{ProductID: 1, Quantity: 1}}},
+		{ID: 5, UserID: 2, TotalPrice: kzt(5000), BaseTotal: kzt(5000), OrderDate: day, Status: domain.OrderStatusPaid, Items: []domain.OrderItem{{ProductID: 1, Quantity: 1}}},
+	}
+	db.Create(&orders)
+
+	list := func(query string) (ids []uint, nextCursor string) {
+		req, _ := http.NewRequest(http.MethodGet, "/orders?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			Orders     []domain.Order `json:"orders"`
+			NextCursor string         `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		for _, order := range response.Orders {
+			ids = append(ids, order.ID)
+		}
+		return ids, response.NextCursor
+	}
+
+	filter := "user_id=1&status=paid,shipped&from=2024-07-06&to=2024-07-06&min_total=15&product_id=1&sort=total_price"
+	ids, cursor := list(filter)
+	assert.Equal(t, []uint{3}, ids)
+	assert.Empty(t, cursor)
+
+	ids, cursor = list("user_id=1&sort=-order_date&limit=2")
+	assert.Equal(t, []uint{4, 3}, ids)
+	if assert.NotEmpty(t, cursor) {
+		ids, cursor = list("user_id=1&sort=-order_date&limit=2&cursor=" + cursor)
+		assert.Equal(t, []uint{2, 1}, ids)
+		assert.Empty(t, cursor)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/orders?sort=name", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCreateOrderPersistsItems(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	orderHandler := setupOrderHandler(db)
+
+	user := domain.User{ID: 1}
+	products := []domain.Product{{ID: 1, Price: kzt(1000), Quantity: 5}, {ID: 2, Price: kzt(2550), Quantity: 5}}
+	db.Create(&user)
+	db.Create(&products)
+
+	order := domain.Order{
+		UserID:     1,
+		ProductIDs: []uint{1},
+		Items:      []domain.OrderItem{{ProductID: 2, Quantity: 2}, {ProductID: 1, Quantity: 1}},
+		TotalPrice: kzt(7100),
+		Status:     "new",
+	}
+	body, _ := json.Marshal(order)
+
+	req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	orderHandler.CreateOrder(c)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	orders, err := orderRepo.GetAllOrders()
+	if err != nil {
+		t.Fatalf("failed to load orders: %v", err)
+	}
+	if assert.Len(t, orders, 1) {
+		saved, err := orderRepo.GetOrderById(orders[0].ID)
+		if err != nil {
+			t.Fatalf("failed to load order: %v", err)
+		}
+		assert.Len(t, saved.Items, 2)
+		for _, item := range saved.Items {
+			switch item.ProductID {
+			case 1:
+				assert.Equal(t, 2, item.Quantity)
+				assert.Equal(t, kzt(1000), item.UnitPrice)
+			case 2:
+				assert.Equal(t, 2, item.Quantity)
+				assert.Equal(t, kzt(2550), item.UnitPrice)
+			}
+		}
+		assert.ElementsMatch(t, []uint{1, 2}, saved.ProductIDs)
+	}
+}
+
+func TestCreateOrderRejectsMismatchedTotal(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	orderHandler := setupOrderHandler(db)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(4999), Quantity: 5})
+
+	order := domain.Order{
+		UserID:     1,
+		Items:      []domain.OrderItem{{ProductID: 1, Quantity: 2}},
+		TotalPrice: kzt(1),
+		Status:     "new",
+	}
+	body, _ := json.Marshal(order)
+
+	req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	orderHandler.CreateOrder(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response map[string]interface{}
 	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
 		t.Fatalf("failed to unmarshal response: %v", err)
 	}
+	assert.Equal(t, map[string]interface{}{"amount": 9998.0, "currency": "KZT"}, response["expected_total"])
+
+	orders, _ := orderRepo.GetAllOrders()
+	assert.Len(t, orders, 0)
+}
+
+func TestCreateOrderInsufficientStock(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	productRepo := repository.NewProductRepository(db)
+
+	orderHandler := setupOrderHandler(db)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&[]domain.Product{{ID: 1, Price: kzt(1000), Quantity: 1}, {ID: 2, Price: kzt(1000), Quantity: 10}})
+
+	order := domain.Order{
+		UserID: 1,
+		Items:  []domain.OrderItem{{ProductID: 1, Quantity: 3}, {ProductID: 2, Quantity: 1}},
+	}
+	body, _ := json.Marshal(order)
+
+	req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	orderHandler.CreateOrder(c)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"Insufficient stock","items":[{"product_id":1,"requested":3,"available":1}]}`, w.Body.String())
+
+	product, _ := productRepo.GetProductByID("2")
+	assert.Equal(t, 10, product.Quantity)
+}
+
+func TestSaveOrderLastUnitConcurrently(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 1})
+
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			order := domain.Order{
+				UserID: 1,
+				Items:  []domain.OrderItem{{ProductID: 1, Quantity: 1, UnitPrice: kzt(1000)}},
+				Status: "new",
+			}
+			results <- orderRepo.SaveOrder(&order)
+		}()
+	}
+
+	var succeeded int
+	for i := 0; i < 2; i++ {
+		if err := <-results; err == nil {
+			succeeded++
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+
+	var product domain.Product
+	db.First(&product, 1)
+	assert.Equal(t, 0, product.Quantity)
+}
+
+func TestTransitionOrder(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+
+	orderHandler := setupOrderHandler(db)
+
+	router := gin.New()
+	router.POST("/orders/:id/transitions", orderHandler.TransitionOrder)
+	router.GET("/orders/:id/history", orderHandler.GetOrderHistory)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+
+	order := domain.Order{
+		UserID: 1,
+		Items:  []domain.OrderItem{{ProductID: 1, Quantity: 1, UnitPrice: kzt(1000)}},
+		Status: domain.OrderStatusPendingPayment,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+	path := "/orders/" + strconv.Itoa(int(order.ID))
+
+	transition := func(status string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(domain.OrderTransition{Status: status, Actor: "admin", Reason: "test"})
+		req, _ := http.NewRequest(http.MethodPost, path+"/transitions", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusConflict, transition(domain.OrderStatusPaid).Code)
+	assert.Equal(t, http.StatusConflict, transition(domain.OrderStatusProcessing).Code)
+	if _, err := orderRepo.TransitionOrder(order.ID, domain.OrderStatusPaid, "admin", "test"); err != nil {
+		t.Fatalf("failed to mark order paid: %v", err)
+	}
+
+	assert.Equal(t, http.StatusConflict, transition(domain.OrderStatusCancelled).Code)
+	assert.Equal(t, http.StatusConflict, transition(domain.OrderStatusRefunded).Code)
+	assert.Equal(t, http.StatusConflict, transition(domain.OrderStatusShipped).Code)
+	assert.Equal(t, http.StatusOK, transition(domain.OrderStatusProcessing).Code)
+
+	var product domain.Product
+	db.First(&product, 1)
+	assert.Equal(t, 4, product.Quantity)
+
+	req, _ := http.NewRequest(http.MethodGet, path+"/history", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var history []domain.OrderStatusHistory
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, domain.OrderStatusPendingPayment, history[0].ToStatus)
+		assert.Equal(t, domain.OrderStatusPaid, history[1].ToStatus)
+		assert.Equal(t, domain.OrderStatusPaid, history[2].FromStatus)
+		assert.Equal(t, domain.OrderStatusProcessing, history[2].ToStatus)
+		assert.Equal(t, "admin", history[2].Actor)
+	}
+}
+
+func TestUpdateOrderOnlyChangesShippingAddress(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	orderHandler := setupOrderHandler(db)
+
+	router := gin.New()
+	router.PUT("/orders/:id", orderHandler.UpdateOrder)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.User{ID: 2})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+
+	order := domain.Order{
+		UserID:          1,
+		Items:           []domain.OrderItem{{ProductID: 1, Quantity: 1, UnitPrice: kzt(1000)}},
+		TotalPrice:      kzt(1000),
+		BaseTotal:       kzt(1000),
+		ShippingAddress: "Old Street 1",
+		Status:          domain.OrderStatusPendingPayment,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	body, _ := json.Marshal(gin.H{
+		"user_id":          2,
+		"product_ids":      []uint{1},
+		"currency":         "USD",
+		"total_price":      domain.Money{Amount: 1, Currency: "USD"},
+		"base_total":       kzt(1),
+		"exchange_rate":    2.5,
+		"shipping_address": "New Street 2",
+	})
+	req, _ := http.NewRequest(http.MethodPut, "/orders/"+strconv.Itoa(int(order.ID)), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var updated domain.Order
+	db.First(&updated, order.ID)
+	assert.Equal(t, "New Street 2", updated.ShippingAddress)
+	assert.Equal(t, uint(1), updated.UserID)
+	assert.Equal(t, "KZT", updated.Currency)
+	assert.Equal(t, kzt(1000), updated.TotalPrice)
+	assert.Equal(t, kzt(1000), updated.BaseTotal)
+	assert.Equal(t, 1.0, updated.ExchangeRate)
+}
+
+func TestCancelOrderRestoresStock(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	productRepo := repository.NewProductRepository(db)
+
+	orderHandler := setupOrderHandler(db)
+
+	router := gin.New()
+	router.POST("/orders/:id/cancel", orderHandler.CancelOrder)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+
+	order := domain.Order{
+		UserID: 1,
+		Items:  []domain.OrderItem{{ProductID: 1, Quantity: 3, UnitPrice: kzt(1000)}},
+		Status: domain.OrderStatusPendingPayment,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	cancel := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(domain.OrderCancellation{Actor: "customer", Reason: "changed my mind"})
+		req, _ := http.NewRequest(http.MethodPost, "/orders/"+strconv.Itoa(int(order.ID))+"/cancel", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, cancel().Code)
+
+	product, _ := productRepo.GetProductByID("1")
+	assert.Equal(t, 5, product.Quantity)
+
+	cancelled, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusCancelled, cancelled.Status)
+
+	assert.Equal(t, http.StatusConflict, cancel().Code)
+}
+
+func TestDeleteOrderOnlyWhenCancelled(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	orderHandler := setupOrderHandler(db)
+
+	router := gin.New()
+	router.DELETE("/orders/:id", orderHandler.DeleteOrder)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+
+	order := domain.Order{
+		UserID: 1,
+		Items:  []domain.OrderItem{{ProductID: 1, Quantity: 3, UnitPrice: kzt(1000)}},
+		Status: domain.OrderStatusPendingPayment,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	deleteOrder := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodDelete, "/orders/"+strconv.Itoa(int(order.ID)), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
 
-	if len(response) != 2 {
-		t.Errorf("handler returned unexpected number of orders: got %v want %v", len(response), 2)
+	assert.Equal(t, http.StatusConflict, deleteOrder().Code)
+
+	if _, err := orderRepo.CancelOrder(order.ID, "admin", "test"); err != nil {
+		t.Fatalf("failed to cancel order: %v", err)
 	}
+	payment := domain.Payment{UserID: 1, OrderID: order.ID, Amount: kzt(3000), PaymentStatus: domain.PaymentStatusFailed}
+	db.Create(&payment)
+	assert.Equal(t, http.StatusConflict, deleteOrder().Code)
+
+	db.Delete(&payment)
+	assert.Equal(t, http.StatusOK, deleteOrder().Code)
+	assert.Equal(t, http.StatusNotFound, deleteOrder().Code)
+
+	var product domain.Product
+	db.First(&product, 1)
+	assert.Equal(t, 5, product.Quantity)
+}
+
+func TestCancelOrderReleasesPayments(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	gateway := service.NewFakeGateway()
+	orderHandler := setupOrderHandler(db)
+	orderHandler.Gateway = gateway
+
+	router := gin.New()
+	router.POST("/orders/:id/cancel", orderHandler.CancelOrder)
+
+	db.Create(&domain.User{ID: 1})
+
+	// charge saves a payment for the order with the gateway in the given
+	// status; an empty status leaves it pending and unknown to the gateway.
+	charge := func(order *domain.Order, invoiceID, status string) *domain.Payment {
+		payment := &domain.Payment{OrderID: order.ID, UserID: 1, Amount: order.TotalPrice, InvoiceID: invoiceID}
+		if err := paymentRepo.CreatePayment(payment); err != nil {
+			t.Fatalf("failed to save payment: %v", err)
+		}
+		if status == "" {
+			return payment
+		}
+		result, _ := gateway.Authorize(context.Background(), &service.PaymentRequest{InvoiceID: invoiceID, Amount: payment.Amount})
+		payment.TransactionID = result.TransactionID
+		assert.NoError(t, paymentRepo.TransitionPayment(payment, domain.PaymentStatusAuthorized, "authorized"))
+		if status == domain.PaymentStatusCaptured {
+			assert.NoError(t, gateway.Capture(context.Background(), payment.TransactionID, payment.Amount))
+			assert.NoError(t, paymentRepo.TransitionPayment(payment, domain.PaymentStatusCaptured, "captured"))
+		}
+		return payment
+	}
+
+	cancel := func(order *domain.Order) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(domain.OrderCancellation{Actor: "customer", Reason: "changed my mind"})
+		req, _ := http.NewRequest(http.MethodPost, "/orders/"+strconv.Itoa(int(order.ID))+"/cancel", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	unpaid := domain.Order{UserID: 1, TotalPrice: kzt(1000), Status: domain.OrderStatusPendingPayment}
+	paid := domain.Order{UserID: 1, TotalPrice: kzt(2000), Status: domain.OrderStatusPendingPayment}
+	for _, order := range []*domain.Order{&unpaid, &paid} {
+		if err := orderRepo.SaveOrder(order); err != nil {
+			t.Fatalf("failed to save order: %v", err)
+		}
+	}
+
+	authorized := charge(&unpaid, "000001", domain.PaymentStatusAuthorized)
+	stale := charge(&unpaid, "000002", "")
+	captured := charge(&paid, "000003", domain.PaymentStatusCaptured)
+
+	assert.Equal(t, http.StatusOK, cancel(&unpaid).Code)
+	assert.Equal(t, http.StatusOK, cancel(&paid).Code)
+
+	expected := map[*domain.Payment]string{
+		authorized: domain.PaymentStatusVoided,
+		stale:      domain.PaymentStatusFailed,
+		captured:   domain.PaymentStatusRefunded,
+	}
+	for payment, status := range expected {
+		stored, _ := paymentRepo.GetPaymentByID(strconv.Itoa(int(payment.ID)))
+		assert.Equal(t, status, stored.PaymentStatus)
+	}
+
+	status, err := gateway.GetStatus(context.Background(), "000003")
+	if assert.NoError(t, err) {
+		assert.Equal(t, domain.PaymentStatusRefunded, status.Status)
+	}
+	refunds, _ := paymentRepo.GetRefunds(captured.ID)
+	if assert.Len(t, refunds, 1) {
+		assert.Equal(t, kzt(2000), refunds[0].Amount)
+	}
+
+	assert.Equal(t, http.StatusConflict, cancel(&paid).Code)
+}
+
+func TestCartCheckout(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	if err := db.AutoMigrate(&domain.Cart{}, &domain.CartItem{}); err != nil {
+		t.Fatalf("failed to migrate carts: %v", err)
+	}
+	defer db.Migrator().DropTable(&domain.CartItem{}, &domain.Cart{})
+
+	orderRepo := repository.NewOrderRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	productRepo := repository.NewProductRepository(db)
+	cartRepo := repository.NewCartRepository(db)
+
+	tax := service.NewTableTaxCalculator(repository.NewTaxRateRepository(db), false, "KZ")
+	currency := service.NewCurrencyConverter(repository.NewExchangeRateRepository(db))
+	orderService := service.NewOrderService(productRepo, repository.NewCouponRepository(db), repository.NewShippingRepository(db), tax, currency)
+	cartHandler := handler.NewCartHandler(cartRepo, orderRepo, userRepo, productRepo, orderService)
+
+	router := gin.New()
+	router.POST("/cart/checkout", cartHandler.Checkout)
+	router.GET("/cart/:user_id", cartHandler.GetCart)
+	router.POST("/cart/:user_id/items", cartHandler.AddItem)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Name: "Mug", Price: kzt(750), Quantity: 2})
+
+	body, _ := json.Marshal(domain.CartItem{ProductID: 1, Quantity: 3})
+	req, _ := http.NewRequest(http.MethodPost, "/cart/1/items", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var view domain.CartView
+	if err := json.Unmarshal(w.Body.Bytes(), &view); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	assert.Equal(t, kzt(2250), view.Subtotal)
+	assert.Len(t, view.Warnings, 1)
+
+	checkout := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(domain.CartCheckout{UserID: 1})
+		req, _ := http.NewRequest(http.MethodPost, "/cart/checkout", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusConflict, checkout().Code)
+
+	if err := cartRepo.UpdateItemQuantity(1, 1, 2); err != nil {
+		t.Fatalf("failed to update cart: %v", err)
+	}
+	stale, _ := cartRepo.GetCartByUserID(1)
+	assert.Equal(t, http.StatusCreated, checkout().Code)
+
+	cart, _ := cartRepo.GetCartByUserID(1)
+	assert.Len(t, cart.Items, 0)
+
+	orders, _ := orderRepo.GetAllOrders()
+	if assert.Len(t, orders, 1) {
+		assert.Equal(t, kzt(1500), orders[0].TotalPrice)
+	}
+
+	db.Model(&domain.Product{}).Where("id = ?", 1).Update("quantity", 2)
+	again := domain.Order{UserID: 1, Items: []domain.OrderItem{{ProductID: 1, Quantity: 2}}, Status: domain.OrderStatusPendingPayment}
+	var cartErr *domain.CartChangedError
+	assert.ErrorAs(t, orderRepo.SaveCartOrder(&again, stale), &cartErr)
+
+	orders, _ = orderRepo.GetAllOrders()
+	assert.Len(t, orders, 1)
+	var product domain.Product
+	db.First(&product, 1)
+	assert.Equal(t, 2, product.Quantity)
+}
+
+func TestCreateOrderWithCoupon(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	orderHandler := setupOrderHandler(db)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&[]domain.Product{
+		{ID: 1, Price: kzt(3000), Quantity: 10, Category: "Books"},
+		{ID: 2, Price: kzt(2000), Quantity: 10, Category: "Toys"},
+	})
+	db.Create(&domain.Coupon{Code: "BOOKS10", Type: domain.CouponTypePercentage, Value: 10, Categories: []string{"Books"}, PerUserLimit: 1})
+
+	createOrder := func() *httptest.ResponseRecorder {
+		order := domain.Order{
+			UserID:     1,
+			Items:      []domain.OrderItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 1}},
+			CouponCode: "books10",
+		}
+		body, _ := json.Marshal(order)
+		req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		orderHandler.CreateOrder(c)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, createOrder().Code)
+
+	orders, _ := orderRepo.GetAllOrders()
+	if assert.Len(t, orders, 1) {
+		assert.Equal(t, kzt(8000), orders[0].Subtotal)
+		assert.Equal(t, kzt(600), orders[0].DiscountTotal)
+		assert.Equal(t, kzt(7400), orders[0].TotalPrice)
+		assert.Equal(t, "BOOKS10", orders[0].CouponCode)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, createOrder().Code)
+
+	var coupon domain.Coupon
+	db.Where("code = ?", "BOOKS10").First(&coupon)
+	assert.Equal(t, 1, coupon.UsageCount)
+}
+
+func TestCreateOrderWithTax(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	orderHandler := setupOrderHandler(db)
+
+	db.Create(&domain.User{ID: 1, Address: "Abay Ave 1, Almaty"})
+	db.Create(&[]domain.Product{
+		{ID: 1, Price: kzt(10000), Quantity: 10, Category: "Books"},
+		{ID: 2, Price: kzt(5000), Quantity: 10, Category: "Toys"},
+	})
+	db.Create(&[]domain.TaxRate{
+		{Country: "KZ", Name: "VAT", Rate: 12},
+		{Country: "KZ", Category: "Books", Name: "VAT (books)", Rate: 0},
+	})
+
+	order := domain.Order{
+		UserID:          1,
+		Items:           []domain.OrderItem{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: 1}},
+		ShippingCountry: "KZ",
+	}
+	body, _ := json.Marshal(order)
+	req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	orderHandler.CreateOrder(c)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	orders, _ := orderRepo.GetAllOrders()
+	if assert.Len(t, orders, 1) {
+		assert.Equal(t, kzt(600), orders[0].TaxTotal)
+		assert.Equal(t, kzt(15600), orders[0].TotalPrice)
+		assert.Equal(t, "Abay Ave 1, Almaty", orders[0].ShippingAddress)
+		assert.Len(t, orders[0].TaxLines, 2)
+	}
+}
+
+func TestShippingAndShipments(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	orderHandler := setupOrderHandler(db)
+	shipmentHandler := handler.NewShipmentHandler(repository.NewShipmentRepository(db))
+
+	router := gin.New()
+	router.POST("/orders", orderHandler.CreateOrder)
+	router.POST("/orders/:id/shipments", shipmentHandler.CreateShipment)
+	router.POST("/shipments/:id/deliver", shipmentHandler.DeliverShipment)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(10000), Quantity: 10, Weight: 2})
+	db.Create(&domain.ShippingMethod{
+		ID:      1,
+		Code:    "COURIER",
+		Name:    "Courier",
+		Carrier: "KazPost",
+		Rates: []domain.ShippingRate{
+			{Zone: "KZ", MaxWeight: 5, Price: kzt(1000)},
+			{Zone: "KZ", MinWeight: 5, Price: kzt(2500)},
+		},
+	})
+
+	post := func(path string, payload interface{}) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	methodID := uint(1)
+	w := post("/orders", domain.Order{
+		UserID:           1,
+		Items:            []domain.OrderItem{{ProductID: 1, Quantity: 3}},
+		ShippingCountry:  "KZ",
+		ShippingMethodID: &methodID,
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	orders, _ := orderRepo.GetAllOrders()
+	if !assert.Len(t, orders, 1) {
+		return
+	}
+	order := orders[0]
+	assert.Equal(t, kzt(2500), order.ShippingTotal)
+	assert.Equal(t, kzt(32500), order.TotalPrice)
+
+	path := "/orders/" + strconv.Itoa(int(order.ID)) + "/shipments"
+	shipment := domain.ShipmentRequest{Carrier: "KazPost", TrackingNumber: "KZ123", Actor: "warehouse"}
+	assert.Equal(t, http.StatusConflict, post(path, shipment).Code)
+
+	if _, err := orderRepo.TransitionOrder(order.ID, domain.OrderStatusPaid, "payments", "captured"); err != nil {
+		t.Fatalf("failed to mark order paid: %v", err)
+	}
+
+	w = post(path, shipment)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		Shipment domain.Shipment `json:"shipment"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	shipped, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusShipped, shipped.Status)
+
+	w = post("/shipments/"+strconv.Itoa(int(created.Shipment.ID))+"/deliver", domain.ShipmentDelivery{Actor: "courier"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	delivered, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusCompleted, delivered.Status)
+}
+
+func TestReturnWorkflow(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	productRepo := repository.NewProductRepository(db)
+	returnHandler := handler.NewReturnHandler(repository.NewReturnRepository(db), repository.NewPaymentRepository(db), service.NewFakeGateway())
+
+	router := gin.New()
+	router.POST("/returns", returnHandler.CreateReturn)
+	router.POST("/returns/:id/approve", returnHandler.ApproveReturn)
+	router.POST("/returns/:id/receive", returnHandler.ReceiveReturn)
+	router.POST("/returns/:id/refund", returnHandler.RefundReturn)
+	router.GET("/returns/:id/history", returnHandler.GetReturnHistory)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+
+	order := domain.Order{
+		UserID: 1,
+		Items:  []domain.OrderItem{{ProductID: 1, Quantity: 2, UnitPrice: kzt(1000), LineTotal: kzt(2000)}},
+		Status: domain.OrderStatusPendingPayment,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	post := func(path string, payload interface{}) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	request := domain.ReturnRequest{
+		OrderID: order.ID,
+		Reason:  "damaged",
+		Items:   []domain.ReturnItem{{ProductID: 1, Quantity: 2}},
+	}
+	assert.Equal(t, http.StatusBadRequest, post("/returns", request).Code)
+
+	db.Model(&domain.Order{}).Where("id = ?", order.ID).Update("status", domain.OrderStatusCompleted)
+
+	tooMany := request
+	tooMany.Items = []domain.ReturnItem{{ProductID: 1, Quantity: 3}}
+	assert.Equal(t, http.StatusBadRequest, post("/returns", tooMany).Code)
+
+	w := post("/returns", request)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created struct {
+		Return domain.ReturnRequest `json:"return"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	assert.Equal(t, kzt(2000), created.Return.RefundAmount)
+
+	path := "/returns/" + strconv.Itoa(int(created.Return.ID))
+	assert.Equal(t, http.StatusConflict, post(path+"/refund", domain.ReturnDecision{Actor: "admin"}).Code)
+	assert.Equal(t, http.StatusOK, post(path+"/approve", domain.ReturnDecision{Actor: "admin"}).Code)
+	assert.Equal(t, http.StatusOK, post(path+"/receive", domain.ReturnReceipt{Actor: "warehouse", Restock: true}).Code)
+	assert.Equal(t, http.StatusOK, post(path+"/refund", domain.ReturnDecision{Actor: "admin"}).Code)
+
+	product, _ := productRepo.GetProductByID("1")
+	assert.Equal(t, 5, product.Quantity)
+
+	refunded, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusRefunded, refunded.Status)
+
+	req, _ := http.NewRequest(http.MethodGet, path+"/history", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var history []domain.ReturnStatusHistory
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	assert.Len(t, history, 5)
+}
+
+// failingRefundGateway rejects the first failures refunds.
+type failingRefundGateway struct {
+	*service.FakeGateway
+	failures atomic.Int32
+}
+
+func (g *failingRefundGateway) Refund(ctx context.Context, transactionID string, amount domain.Money) error {
+	if g.failures.Add(-1) >= 0 {
+		return &domain.PaymentGatewayError{Operation: "refund", Reason: "provider unavailable"}
+	}
+	return g.FakeGateway.Refund(ctx, transactionID, amount)
+}
+
+func TestRefundReturnOnce(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	returnRepo := repository.NewReturnRepository(db)
+	gateway := &failingRefundGateway{FakeGateway: service.NewFakeGateway()}
+	gateway.failures.Store(1)
+	returnHandler := handler.NewReturnHandler(returnRepo, paymentRepo, gateway)
+
+	router := gin.New()
+	router.POST("/returns/:id/refund", returnHandler.RefundReturn)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+
+	order := domain.Order{
+		UserID:     1,
+		Items:      []domain.OrderItem{{ProductID: 1, Quantity: 2, UnitPrice: kzt(1000), LineTotal: kzt(2000)}},
+		TotalPrice: kzt(2000),
+		Status:     domain.OrderStatusPendingPayment,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	payment := domain.Payment{OrderID: order.ID, UserID: 1, Amount: kzt(2000), InvoiceID: "000001"}
+	if err := paymentRepo.CreatePayment(&payment); err != nil {
+		t.Fatalf("failed to save payment: %v", err)
+	}
+	result, _ := gateway.Authorize(context.Background(), &service.PaymentRequest{InvoiceID: payment.InvoiceID, Amount: payment.Amount})
+	payment.TransactionID = result.TransactionID
+	assert.NoError(t, gateway.Capture(context.Background(), payment.TransactionID, payment.Amount))
+	assert.NoError(t, paymentRepo.TransitionPayment(&payment, domain.PaymentStatusCaptured, "captured"))
+	db.Model(&domain.Order{}).Where("id = ?", order.ID).Update("status", domain.OrderStatusCompleted)
+
+	ret := domain.ReturnRequest{OrderID: order.ID, Reason: "damaged", Items: []domain.ReturnItem{{ProductID: 1, Quantity: 1}}}
+	if err := returnRepo.CreateReturn(&ret); err != nil {
+		t.Fatalf("failed to create return: %v", err)
+	}
+	_, err := returnRepo.TransitionReturn(ret.ID, domain.ReturnStatusApproved, "admin", "")
+	assert.NoError(t, err)
+	_, err = returnRepo.ReceiveReturn(ret.ID, "warehouse", false, "")
+	assert.NoError(t, err)
+
+	refund := func() int {
+		body, _ := json.Marshal(domain.ReturnDecision{Actor: "admin"})
+		req, _ := http.NewRequest(http.MethodPost, "/returns/"+strconv.Itoa(int(ret.ID))+"/refund", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadGateway, refund())
+
+	const attempts = 3
+	codes := make(chan int, attempts)
+	for i := 0; i < attempts; i++ {
+		go func() { codes <- refund() }()
+	}
+	succeeded := 0
+	for i := 0; i < attempts; i++ {
+		switch code := <-codes; code {
+		case http.StatusOK:
+			succeeded++
+		default:
+			assert.Equal(t, http.StatusConflict, code)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+
+	refunds, _ := paymentRepo.GetRefunds(payment.ID)
+	var completed []domain.Refund
+	for _, r := range refunds {
+		if r.Status == domain.RefundStatusCompleted {
+			completed = append(completed, r)
+		}
+	}
+	if assert.Len(t, completed, 1) {
+		assert.Equal(t, kzt(1000), completed[0].Amount)
+		assert.Equal(t, ret.ID, *completed[0].ReturnRequestID)
+	}
+
+	status, err := gateway.GetStatus(context.Background(), payment.InvoiceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, domain.PaymentStatusPartiallyRefunded, status.Status)
+	}
+
+	// The last unit's refund can only partly be covered once the payment has
+	// been refunded outside the return.
+	outside := domain.Refund{Amount: kzt(600), Reason: "goodwill"}
+	if err := paymentRepo.CreateRefund(&payment, &outside); err != nil {
+		t.Fatalf("failed to reserve refund: %v", err)
+	}
+	assert.NoError(t, paymentRepo.CompleteRefund(&payment, &outside, false))
+
+	rest := domain.ReturnRequest{OrderID: order.ID, Reason: "damaged", Items: []domain.ReturnItem{{ProductID: 1, Quantity: 1}}}
+	if err := returnRepo.CreateReturn(&rest); err != nil {
+		t.Fatalf("failed to create return: %v", err)
+	}
+	_, err = returnRepo.TransitionReturn(rest.ID, domain.ReturnStatusApproved, "admin", "")
+	assert.NoError(t, err)
+	_, err = returnRepo.ReceiveReturn(rest.ID, "warehouse", false, "")
+	assert.NoError(t, err)
+
+	ret = rest
+	assert.Equal(t, http.StatusConflict, refund())
+	short, _ := returnRepo.GetReturnByID(rest.ID)
+	assert.Equal(t, domain.ReturnStatusRefunding, short.Status)
+	unreturned, _ := orderRepo.GetOrderById(order.ID)
+	assert.NotEqual(t, domain.OrderStatusRefunded, unreturned.Status)
+}
+
+func TestCreateOrderIdempotencyKey(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	orderHandler := setupOrderHandler(db)
+
+	router := gin.New()
+	router.POST("/orders", handler.Idempotency(repository.NewIdempotencyRepository(db), time.Hour), orderHandler.CreateOrder)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+
+	post := func(key string, quantity int) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(domain.Order{UserID: 1, Items: []domain.OrderItem{{ProductID: 1, Quantity: quantity}}})
+		req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(handler.IdempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("order-1", 1)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	retry := post("order-1", 1)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+
+	assert.Equal(t, http.StatusConflict, post("order-1", 2).Code)
+
+	orders, _ := orderRepo.GetAllOrders()
+	assert.Len(t, orders, 1)
+}
+
+// unrecordableGateway charges the card but reports a status the payment
+// lifecycle rejects, so the payment fails after the charge.
+type unrecordableGateway struct {
+	*service.FakeGateway
+	authorized atomic.Int32
+}
+
+func (g *unrecordableGateway) Authorize(ctx context.Context, request *service.PaymentRequest) (*service.PaymentResult, error) {
+	g.authorized.Add(1)
+	result, err := g.FakeGateway.Authorize(ctx, request)
+	if err == nil {
+		result.Status = domain.PaymentStatusRefunded
+	}
+	return result, err
+}
+
+func TestIdempotencyKeyAfterServerError(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	idempotencyRepo := repository.NewIdempotencyRepository(db)
+	orderRepo := repository.NewOrderRepository(db)
+	gateway := &unrecordableGateway{FakeGateway: service.NewFakeGateway()}
+	paymentHandler := handler.NewPaymentHandler(repository.NewPaymentRepository(db), orderRepo, repository.NewUserRepository(db), gateway, service.NewHomebankSigner("test-secret"))
+
+	var panicked atomic.Bool
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.POST("/payments", handler.Idempotency(idempotencyRepo, time.Hour), paymentHandler.CreatePayment)
+	router.POST("/flaky", handler.Idempotency(idempotencyRepo, time.Hour), func(c *gin.Context) {
+		if !panicked.Swap(true) {
+			panic("boom")
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "ok"})
+	})
+
+	db.Create(&domain.User{ID: 1})
+	order := domain.Order{UserID: 1, TotalPrice: kzt(1500), Status: domain.OrderStatusPendingPayment}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	post := func(path, key string, payload interface{}) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(payload)
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(handler.IdempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	payment := domain.PaymentCreate{OrderID: order.ID, PaymentCard: testCard}
+	assert.Equal(t, http.StatusInternalServerError, post("/payments", "payment-1", payment).Code)
+	retry := post("/payments", "payment-1", payment)
+	assert.Equal(t, http.StatusInternalServerError, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, int32(1), gateway.authorized.Load())
+
+	assert.Equal(t, http.StatusInternalServerError, post("/flaky", "flaky-1", gin.H{}).Code)
+	assert.Equal(t, http.StatusOK, post("/flaky", "flaky-1", gin.H{}).Code)
+
+	db.Model(&domain.IdempotencyKey{}).Where("key = ?", "flaky-1").Update("expires_at", time.Now().Add(-time.Minute))
+	purged, err := idempotencyRepo.PurgeExpired(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+}
+
+func TestCreateOrderInForeignCurrency(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	orderHandler := setupOrderHandler(db)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(49999), Quantity: 5})
+
+	post := func(currency string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(domain.Order{UserID: 1, Currency: currency, Items: []domain.OrderItem{{ProductID: 1, Quantity: 3}}})
+		req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		orderHandler.CreateOrder(c)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post("USD").Code)
+
+	db.Create(&domain.ExchangeRate{From: "USD", To: domain.BaseCurrency, Rate: 500})
+	assert.Equal(t, http.StatusCreated, post("USD").Code)
+
+	orders, _ := orderRepo.GetAllOrders()
+	if assert.Len(t, orders, 1) {
+		order := orders[0]
+		assert.Equal(t, "USD", order.Currency)
+		assert.Equal(t, domain.NewMoney(100, "USD"), order.Items[0].UnitPrice)
+		assert.Equal(t, domain.NewMoney(300, "USD"), order.TotalPrice)
+		assert.Equal(t, kzt(150000), order.BaseTotal)
+		assert.Equal(t, 500.0, order.ExchangeRate)
+	}
+
+	usd := domain.NewMoney(300, "USD")
+	assert.Panics(t, func() { usd.Add(kzt(100)) })
+	assert.Panics(t, func() { usd.Sub(kzt(100)) })
+	assert.Panics(t, func() { usd.Min(kzt(100)) })
+	assert.Equal(t, usd, usd.Sub(kzt(0)))
+	assert.Equal(t, usd, domain.Money{}.Add(usd))
+}
+
+type recordingPublisher struct {
+	events []domain.OrderEvent
+}
+
+func (p *recordingPublisher) Publish(event domain.OrderEvent) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+func TestExpireUnpaidOrders(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	productRepo := repository.NewProductRepository(db)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+
+	now := time.Now()
+	placed := []time.Time{now.Add(-2 * time.Hour), now.Add(-2 * time.Hour), now.Add(-time.Minute)}
+	orders := make([]domain.Order, len(placed))
+	for i, orderDate := range placed {
+		orders[i] = domain.Order{
+			UserID:    1,
+			OrderDate: orderDate,
+			Items:     []domain.OrderItem{{ProductID: 1, Quantity: 1, UnitPrice: kzt(1000)}},
+			Status:    domain.OrderStatusPendingPayment,
+		}
+		if err := orderRepo.SaveOrder(&orders[i]); err != nil {
+			t.Fatalf("failed to save order: %v", err)
+		}
+	}
+	db.Create(&domain.Payment{UserID: 1, OrderID: orders[1].ID, Amount: kzt(1000)})
+
+	publisher := &recordingPublisher{}
+	worker := service.NewOrderExpiryWorker(orderRepo, publisher, 30*time.Minute, time.Minute)
+
+	expired, err := worker.ExpireOrders(now)
+	if err != nil {
+		t.Fatalf("failed to expire orders: %v", err)
+	}
+	if assert.Len(t, expired, 1) {
+		assert.Equal(t, orders[0].ID, expired[0].ID)
+	}
+	if assert.Len(t, publisher.events, 1) {
+		assert.Equal(t, domain.OrderEventExpired, publisher.events[0].Type)
+		assert.Equal(t, domain.OrderStatusCancelled, publisher.events[0].Status)
+	}
+
+	cancelled, _ := orderRepo.GetOrderById(orders[0].ID)
+	assert.Equal(t, domain.OrderStatusCancelled, cancelled.Status)
+	product, _ := productRepo.GetProductByID("1")
+	assert.Equal(t, 3, product.Quantity)
+
+	expired, _ = worker.ExpireOrders(now)
+	assert.Len(t, expired, 0)
+}
+
+func TestPartialFulfilment(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	shipmentRepo := repository.NewShipmentRepository(db)
+	shipmentHandler := handler.NewShipmentHandler(shipmentRepo)
+
+	router := gin.New()
+	router.POST("/orders/:id/shipments", shipmentHandler.CreateShipment)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&[]domain.Product{{ID: 1, Price: kzt(1000), Quantity: 10}, {ID: 2, Price: kzt(500), Quantity: 10}})
+
+	order := domain.Order{
+		UserID: 1,
+		Items:  []domain.OrderItem{{ProductID: 1, Quantity: 3}, {ProductID: 2, Quantity: 1}},
+		Status: domain.OrderStatusPaid,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	path := "/orders/" + strconv.Itoa(int(order.ID)) + "/shipments"
+	ship := func(items ...domain.ShipmentItem) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(domain.ShipmentRequest{Carrier: "KazPost", TrackingNumber: "KZ1", Actor: "warehouse", Items: items})
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, ship(domain.ShipmentItem{ProductID: 1, Quantity: 2}).Code)
+	partial, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusPartiallyFulfilled, partial.Status)
+
+	assert.Equal(t, http.StatusBadRequest, ship(domain.ShipmentItem{ProductID: 1, Quantity: 2}).Code)
+	assert.Equal(t, http.StatusBadRequest, ship(domain.ShipmentItem{ProductID: 3, Quantity: 1}).Code)
+
+	assert.Equal(t, http.StatusCreated, ship().Code)
+	shipped, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusShipped, shipped.Status)
+	for _, item := range shipped.Items {
+		assert.Equal(t, item.Quantity, item.FulfilledQuantity)
+	}
+
+	assert.Equal(t, http.StatusConflict, ship().Code)
+
+	shipments, _ := shipmentRepo.GetShipmentsByOrderID(order.ID)
+	if assert.Len(t, shipments, 2) {
+		assert.Len(t, shipments[0].Items, 1)
+		assert.Len(t, shipments[1].Items, 2)
+	}
+}
+
+func TestCancelPartiallyFulfilledOrder(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	shipmentRepo := repository.NewShipmentRepository(db)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 10})
+
+	order := domain.Order{
+		UserID: 1,
+		Items:  []domain.OrderItem{{ProductID: 1, Quantity: 3}},
+		Status: domain.OrderStatusPaid,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	request := domain.ShipmentRequest{Carrier: "KazPost", TrackingNumber: "KZ1", Actor: "warehouse", Items: []domain.ShipmentItem{{ProductID: 1, Quantity: 2}}}
+	if _, err := shipmentRepo.CreateShipment(order.ID, &request); err != nil {
+		t.Fatalf("failed to ship order: %v", err)
+	}
+
+	cancelled, err := orderRepo.CancelOrder(order.ID, "admin", "customer request")
+	if assert.NoError(t, err) {
+		assert.Equal(t, domain.OrderStatusCancelled, cancelled.Status)
+	}
+
+	var product domain.Product
+	db.First(&product, 1)
+	assert.Equal(t, 8, product.Quantity)
+}
+
+func TestBackordersAllocatedFirstInFirstOut(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	productRepo := repository.NewProductRepository(db)
+
+	db.Create(&domain.User{ID: 1})
+	product := domain.Product{ID: 1, Name: "Console", Description: "Next generation", Category: "Games", Price: kzt(30000000), Quantity: 1, AllowBackorder: true, MaxBackorder: 3}
+	db.Create(&product)
+
+	place := func(quantity int) (*domain.Order, error) {
+		order := domain.Order{
+			UserID: 1,
+			Items:  []domain.OrderItem{{ProductID: 1, Quantity: quantity}},
+			Status: domain.OrderStatusPendingPayment,
+		}
+		return &order, orderRepo.SaveOrder(&order)
+	}
+
+	first, err := place(2)
+	if err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+	assert.Equal(t, 1, first.Items[0].Backordered)
+
+	second, err := place(2)
+	if err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+	assert.Equal(t, 2, second.Items[0].Backordered)
+
+	_, err = place(1)
+	var stockErr *domain.InsufficientStockError
+	assert.ErrorAs(t, err, &stockErr)
+
+	product.Quantity = 2
+	if err := productRepo.UpdateProduct("1", &product, []string{"quantity"}); err != nil {
+		t.Fatalf("failed to update product: %v", err)
+	}
+
+	first, _ = orderRepo.GetOrderById(first.ID)
+	second, _ = orderRepo.GetOrderById(second.ID)
+	assert.Equal(t, 0, first.Items[0].Backordered)
+	assert.Equal(t, 1, second.Items[0].Backordered)
+
+	updated, _ := productRepo.GetProductByID("1")
+	assert.Equal(t, 0, updated.Quantity)
+	assert.Equal(t, 1, updated.Backordered)
+}
+
+func TestExportOrders(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	exporter := service.NewExporter(orderRepo, repository.NewPaymentRepository(db), repository.NewUserRepository(db))
+	exportHandler := handler.NewExportHandler(exporter)
+
+	router := gin.New()
+	router.GET("/exports/orders", exportHandler.ExportOrders)
+
+	db.Create(&[]domain.User{{ID: 1, Name: "Aigerim", Email: "aigerim@example.com"}, {ID: 2, Name: "Dias"}})
+	db.Create(&[]domain.Product{{ID: 1, Name: "Mug", Price: kzt(1000), Quantity: 10}, {ID: 2, Name: "Tea", Price: kzt(550), Quantity: 10}})
+	orders := []domain.Order{
+		{UserID: 1, Status: domain.OrderStatusPaid, Items: []domain.OrderItem{
+			{ProductID: 1, Quantity: 2, UnitPrice: kzt(1000), LineTotal: kzt(2000)},
+			{ProductID: 2, Quantity: 1, UnitPrice: kzt(550), LineTotal: kzt(550)},
+		}},
+		{UserID: 2, Status: domain.OrderStatusPaid, Items: []domain.OrderItem{{ProductID: 1, Quantity: 1}}},
+	}
+	for i := range orders {
+		if err := orderRepo.SaveOrder(&orders[i]); err != nil {
+			t.Fatalf("failed to save order: %v", err)
+		}
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodGet, "/exports/orders?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("user_id=1&sort=id")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse export: %v", err)
+	}
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, domain.OrderExportHeader, records[0])
+		assert.Equal(t, []string{"Aigerim", "Mug", "2", "20.00"}, []string{records[1][4], records[1][8], records[1][9], records[1][11]})
+		assert.Equal(t, "Tea", records[2][8])
+	}
+
+	w = get("format=ndjson")
+	assert.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if assert.Len(t, lines, 3) {
+		var row domain.OrderExportRow
+		if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+			t.Fatalf("failed to unmarshal row: %v", err)
+		}
+		assert.Equal(t, orders[1].ID, row.OrderID)
+		assert.Equal(t, "Dias", row.UserName)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, get("format=xml").Code)
+}
+
+func TestExportPayments(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	exporter := service.NewExporter(repository.NewOrderRepository(db), repository.NewPaymentRepository(db), repository.NewUserRepository(db))
+	exportHandler := handler.NewExportHandler(exporter)
+
+	router := gin.New()
+	router.GET("/exports/payments", exportHandler.ExportPayments)
+
+	db.Create(&domain.User{ID: 1, Name: "Aigerim"})
+	db.Create(&[]domain.Payment{
+		{UserID: 1, OrderID: 1, Amount: kzt(1000), PaymentStatus: domain.PaymentStatusCaptured},
+		{UserID: 1, OrderID: 1, Amount: kzt(1000), PaymentStatus: domain.PaymentStatusFailed},
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/exports/payments", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse export: %v", err)
+	}
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, domain.PaymentExportHeader, records[0])
+		assert.Equal(t, []string{domain.PaymentStatusCaptured, domain.PaymentStatusFailed}, []string{records[1][2], records[2][2]})
+	}
+}
+
+func TestPaymentWithFakeGateway(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	gateway := service.NewFakeGateway()
+	paymentHandler := handler.NewPaymentHandler(repository.NewPaymentRepository(db), orderRepo, repository.NewUserRepository(db), gateway, service.NewHomebankSigner("test-secret"))
+	orderHandler := setupOrderHandler(db)
+	orderHandler.Gateway = gateway
+
+	router := gin.New()
+	router.POST("/payments", paymentHandler.CreatePayment)
+	router.POST("/orders/:id/cancel", orderHandler.CancelOrder)
+
+	db.Create(&domain.User{ID: 1, Name: "Arman Ali", Email: "arman@example.com"})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+
+	order := domain.Order{
+		UserID:     1,
+		Items:      []domain.OrderItem{{ProductID: 1, Quantity: 2, UnitPrice: kzt(1000)}},
+		TotalPrice: kzt(2000),
+		Status:     domain.OrderStatusPendingPayment,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	pay := func(amount domain.Money) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(domain.PaymentCreate{UserID: 1, OrderID: order.ID, Amount: amount, PaymentCard: testCard})
+		req, _ := http.NewRequest(http.MethodPost, "/payments", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, pay(kzt(100)).Code)
+
+	gateway.Decline = true
+	assert.Equal(t, http.StatusPaymentRequired, pay(kzt(2000)).Code)
+
+	gateway.Decline = false
+	w := pay(kzt(2000))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, http.StatusConflict, pay(kzt(2000)).Code)
+
+	var payment domain.Payment
+	_ = json.Unmarshal(w.Body.Bytes(), &payment)
+	assert.NotEmpty(t, payment.TransactionID)
+	assert.Equal(t, domain.PaymentStatusAuthorized, payment.PaymentStatus)
+	assert.Equal(t, fmt.Sprintf("%06d", payment.ID), payment.InvoiceID)
+
+	body, _ := json.Marshal(domain.OrderCancellation{Actor: "customer", Reason: "changed my mind"})
+	req, _ := http.NewRequest(http.MethodPost, "/orders/"+strconv.Itoa(int(order.ID))+"/cancel", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	status, err := gateway.GetStatus(context.Background(), payment.InvoiceID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, domain.PaymentStatusVoided, status.Status)
+	}
+}
+
+func TestCreatePaymentConcurrently(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentHandler := handler.NewPaymentHandler(repository.NewPaymentRepository(db), orderRepo, repository.NewUserRepository(db), service.NewFakeGateway(), service.NewHomebankSigner("test-secret"))
+
+	router := gin.New()
+	router.POST("/payments", paymentHandler.CreatePayment)
+
+	db.Create(&domain.User{ID: 1})
+	order := domain.Order{UserID: 1, TotalPrice: kzt(1500), Status: domain.OrderStatusPendingPayment}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	const attempts = 5
+	codes := make(chan int, attempts)
+	for i := 0; i < attempts; i++ {
+		go func() {
+			body, _ := json.Marshal(domain.PaymentCreate{OrderID: order.ID, PaymentCard: testCard})
+			req, _ := http.NewRequest(http.MethodPost, "/payments", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+
+	created := 0
+	for i := 0; i < attempts; i++ {
+		switch code := <-codes; code {
+		case http.StatusCreated:
+			created++
+		default:
+			assert.Equal(t, http.StatusConflict, code)
+		}
+	}
+	assert.Equal(t, 1, created)
+
+	var payments int64
+	db.Model(&domain.Payment{}).Where("order_id = ?", order.ID).Count(&payments)
+	assert.Equal(t, int64(1), payments)
+}
+
+func TestPaymentLifecycle(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	paymentHandler := handler.NewPaymentHandler(paymentRepo, orderRepo, repository.NewUserRepository(db), service.NewFakeGateway(), service.NewHomebankSigner("test-secret"))
+
+	router := gin.New()
+	router.POST("/payments", paymentHandler.CreatePayment)
+	router.POST("/payments/:id/capture", paymentHandler.CapturePayment)
+
+	db.Create(&domain.User{ID: 1})
+	order := domain.Order{UserID: 1, TotalPrice: kzt(5000), Status: domain.OrderStatusPendingPayment}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	body, _ := json.Marshal(domain.PaymentCreate{OrderID: order.ID, PaymentCard: testCard})
+	req, _ := http.NewRequest(http.MethodPost, "/payments", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var payment domain.Payment
+	_ = json.Unmarshal(w.Body.Bytes(), &payment)
+	assert.Equal(t, kzt(5000), payment.Amount)
+
+	capture := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/payments/"+strconv.Itoa(int(payment.ID))+"/capture", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, capture().Code)
+	assert.Equal(t, http.StatusConflict, capture().Code)
+
+	captured, _ := paymentRepo.SearchPaymentsByStatus(domain.PaymentStatusCaptured)
+	if assert.Len(t, captured, 1) {
+		assert.Equal(t, payment.TransactionID, captured[0].TransactionID)
+	}
+
+	events, _ := paymentRepo.GetPaymentEvents(payment.ID)
+	var statuses []string
+	for _, event := range events {
+		statuses = append(statuses, event.ToStatus)
+	}
+	assert.Equal(t, []string{domain.PaymentStatusPending, domain.PaymentStatusAuthorized, domain.PaymentStatusCaptured}, statuses)
+
+	paid, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusPaid, paid.Status)
+}
+
+// homebankSender stands in for epay, posting signed payment results to the
+// callback endpoints.
+func TestReconcilePendingPayments(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	gateway := service.NewFakeGateway()
+	reconciler := service.NewPaymentReconciler(paymentRepo, gateway, 10*time.Minute, time.Minute)
+
+	db.Create(&domain.User{ID: 1})
+	order := domain.Order{UserID: 1, TotalPrice: kzt(1000), Status: domain.OrderStatusPendingPayment}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	pending := func(invoiceID string) *domain.Payment {
+		payment := &domain.Payment{OrderID: order.ID, UserID: 1, Amount: kzt(1000), InvoiceID: invoiceID}
+		if err := paymentRepo.CreatePayment(payment); err != nil {
+			t.Fatalf("failed to save payment: %v", err)
+		}
+		return payment
+	}
+	authorized := pending("000001")
+	lost := pending("000002")
+	recent := pending("000003")
+	_, _ = gateway.Authorize(context.Background(), &service.PaymentRequest{InvoiceID: authorized.InvoiceID, Amount: authorized.Amount})
+	db.Model(&domain.Payment{}).Where("id = ?", recent.ID).Update("payment_date", time.Now().Add(2*time.Hour))
+
+	settled, err := reconciler.Reconcile(context.Background(), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, settled)
+
+	expected := map[*domain.Payment]string{
+		authorized: domain.PaymentStatusAuthorized,
+		lost:       domain.PaymentStatusFailed,
+		recent:     domain.PaymentStatusPending,
+	}
+	for payment, status := range expected {
+		stored, _ := paymentRepo.GetPaymentByID(strconv.Itoa(int(payment.ID)))
+		assert.Equal(t, status, stored.PaymentStatus)
+	}
+
+	epay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"resultCode":"100","transaction":{"id":"tx-1","invoiceID":"000004","amount":10,"currency":"KZT","statusName":"NEW"}}`))
+	}))
+	defer epay.Close()
+
+	homebank := service.NewHomebankGateway(service.HomebankConfig{})
+	homebank.APIURL = epay.URL
+	homebank.Tokens = service.NewCachedTokenSource(func() (*service.Token, error) {
+		return &service.Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour)}, nil
+	})
+	result, err := homebank.GetStatus(context.Background(), "000004")
+	if assert.NoError(t, err) {
+		assert.Equal(t, domain.PaymentStatusPending, result.Status)
+	}
+}
+
+func TestHomebankGatewayTimeout(t *testing.T) {
+	epay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer epay.Close()
+
+	homebank := service.NewHomebankGateway(service.HomebankConfig{})
+	assert.Equal(t, 30*time.Second, homebank.Client.Timeout)
+
+	homebank = service.NewHomebankGateway(service.HomebankConfig{Timeout: 100 * time.Millisecond})
+	homebank.APIURL = epay.URL
+	homebank.Tokens = service.NewCachedTokenSource(func() (*service.Token, error) {
+		return &service.Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour)}, nil
+	})
+
+	started := time.Now()
+	_, err := homebank.GetStatus(context.Background(), "000001")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(started), 5*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = homebank.Void(ctx, "tx-1")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestHomebankGatewayRequiresCallbackSecret(t *testing.T) {
+	config := service.HomebankConfig{PublicKeyFile: filepath.Join(t.TempDir(), "missing.pem")}
+	for _, secret := range []string{"", " ", "change-me"} {
+		config.Signer = service.NewHomebankSigner(secret)
+		_, err := service.NewPaymentGateway(service.PaymentGatewayHomebank, config)
+		assert.Error(t, err, "secret %q", secret)
+	}
+
+	config.Signer = service.NewHomebankSigner("0b5f8e2c4d7a9f1e3c6b8a0d2f4e6c8a")
+	_, err := service.NewPaymentGateway(service.PaymentGatewayHomebank, config)
+	assert.NoError(t, err)
+
+	_, err = service.NewPaymentGateway(service.PaymentGatewayFake, service.HomebankConfig{})
+	assert.NoError(t, err)
+}
+
+type homebankSender struct {
+	router *gin.Engine
+	signer *service.HomebankSigner
+}
+
+func (s *homebankSender) send(path string, callback service.HomebankCallback) *httptest.ResponseRecorder {
+	if callback.SecretHash == "" {
+		callback.SecretHash = s.signer.Sign(callback.InvoiceID)
+	}
+	body, _ := json.Marshal(callback)
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHomebankCallbacks(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	signer := service.NewHomebankSigner("test-secret")
+	paymentHandler := handler.NewPaymentHandler(paymentRepo, orderRepo, repository.NewUserRepository(db), service.NewFakeGateway(), signer)
+
+	router := gin.New()
+	router.POST(service.HomebankCallbackPath, paymentHandler.HomebankCallback)
+	router.POST(service.HomebankFailureCallbackPath, paymentHandler.HomebankFailureCallback)
+	sender := &homebankSender{router: router, signer: signer}
+
+	db.Create(&domain.User{ID: 1})
+	var payments []domain.Payment
+	for i := 0; i < 2; i++ {
+		order := domain.Order{UserID: 1, TotalPrice: kzt(2500), Status: domain.OrderStatusPendingPayment}
+		if err := orderRepo.SaveOrder(&order); err != nil {
+			t.Fatalf("failed to save order: %v", err)
+		}
+		payment := domain.Payment{UserID: 1, OrderID: order.ID, Amount: kzt(2500)}
+		if err := paymentRepo.CreatePayment(&payment); err != nil {
+			t.Fatalf("failed to save payment: %v", err)
+		}
+		payments = append(payments, payment)
+	}
+
+	success := service.HomebankCallback{ID: "hb-1", InvoiceID: payments[0].InvoiceID, Amount: "25.00", Currency: "KZT", Code: "ok"}
+
+	forged := success
+	forged.SecretHash = "forged"
+	assert.Equal(t, http.StatusUnauthorized, sender.send(service.HomebankCallbackPath, forged).Code)
+
+	wrongAmount := success
+	wrongAmount.Amount = "1.00"
+	assert.Equal(t, http.StatusBadRequest, sender.send(service.HomebankCallbackPath, wrongAmount).Code)
+
+	assert.Equal(t, http.StatusOK, sender.send(service.HomebankCallbackPath, success).Code)
+	assert.Equal(t, http.StatusOK, sender.send(service.HomebankCallbackPath, success).Code)
+
+	paid, _ := paymentRepo.GetPaymentByID(strconv.Itoa(int(payments[0].ID)))
+	assert.Equal(t, domain.PaymentStatusCaptured, paid.PaymentStatus)
+	assert.Equal(t, "hb-1", paid.TransactionID)
+	events, _ := paymentRepo.GetPaymentEvents(paid.ID)
+	assert.Len(t, events, 2)
+	order, _ := orderRepo.GetOrderById(paid.OrderID)
+	assert.Equal(t, domain.OrderStatusPaid, order.Status)
+
+	failure := service.HomebankCallback{InvoiceID: payments[1].InvoiceID, Amount: "25.00", Currency: "KZT", Code: "error", Reason: "Insufficient funds", ReasonCode: "05"}
+	assert.Equal(t, http.StatusOK, sender.send(service.HomebankFailureCallbackPath, failure).Code)
+
+	failed, _ := paymentRepo.GetPaymentByID(strconv.Itoa(int(payments[1].ID)))
+	assert.Equal(t, domain.PaymentStatusFailed, failed.PaymentStatus)
+	assert.Equal(t, "Insufficient funds 05", failed.FailureReason)
+	order, _ = orderRepo.GetOrderById(failed.OrderID)
+	assert.Equal(t, domain.OrderStatusPendingPayment, order.Status)
+}
+
+func TestRefundPayment(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	paymentHandler := handler.NewPaymentHandler(paymentRepo, orderRepo, repository.NewUserRepository(db), service.NewFakeGateway(), service.NewHomebankSigner("test-secret"))
+
+	router := gin.New()
+	router.POST("/payments", paymentHandler.CreatePayment)
+	router.PUT("/payments/:id", paymentHandler.UpdatePayment)
+	router.POST("/payments/:id/capture", paymentHandler.CapturePayment)
+	router.POST("/payments/:id/refunds", paymentHandler.RefundPayment)
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, path, bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	db.Create(&domain.User{ID: 1})
+	order := domain.Order{UserID: 1, TotalPrice: kzt(5000), Status: domain.OrderStatusPendingPayment}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	w := send(http.MethodPost, "/payments", domain.PaymentCreate{OrderID: order.ID, PaymentCard: testCard})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var payment domain.Payment
+	_ = json.Unmarshal(w.Body.Bytes(), &payment)
+	path := "/payments/" + strconv.Itoa(int(payment.ID))
+
+	refund := func(amount int64) *httptest.ResponseRecorder {
+		return send(http.MethodPost, path+"/refunds", domain.RefundRequest{Amount: kzt(amount), Reason: "damaged"})
+	}
+	assert.Equal(t, http.StatusConflict, refund(1000).Code)
+	assert.Equal(t, http.StatusConflict, send(http.MethodPut, path, domain.Payment{UserID: 1, OrderID: order.ID + 1, Amount: kzt(1)}).Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, path+"/capture", nil).Code)
+	assert.Equal(t, http.StatusConflict, send(http.MethodPut, path, domain.Payment{UserID: 1, OrderID: order.ID, Amount: kzt(1)}).Code)
+
+	assert.Equal(t, http.StatusCreated, refund(1000).Code)
+	partial, _ := paymentRepo.GetPaymentByID(strconv.Itoa(int(payment.ID)))
+	assert.Equal(t, domain.PaymentStatusPartiallyRefunded, partial.PaymentStatus)
+	assert.Equal(t, kzt(5000), partial.Amount)
+	partialOrder, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusPaid, partialOrder.Status)
+
+	assert.Equal(t, http.StatusBadRequest, refund(4001).Code)
+	assert.Equal(t, http.StatusCreated, refund(4000).Code)
+	assert.Equal(t, http.StatusConflict, refund(1).Code)
+
+	refunded, _ := paymentRepo.GetPaymentByID(strconv.Itoa(int(payment.ID)))
+	assert.Equal(t, domain.PaymentStatusRefunded, refunded.PaymentStatus)
+	refundedOrder, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusRefunded, refundedOrder.Status)
+
+	refunds, _ := paymentRepo.GetRefunds(payment.ID)
+	if assert.Len(t, refunds, 2) {
+		assert.Equal(t, domain.RefundStatusCompleted, refunds[0].Status)
+		assert.Equal(t, kzt(4000), refunds[1].Amount)
+	}
+}
+
+func TestDeletePaymentKeepsMoneyMovements(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	paymentHandler := handler.NewPaymentHandler(paymentRepo, orderRepo, repository.NewUserRepository(db), service.NewFakeGateway(), service.NewHomebankSigner("test-secret"))
+
+	router := gin.New()
+	router.DELETE("/payments/:id", paymentHandler.DeletePayment)
+	remove := func(payment *domain.Payment) int {
+		req, _ := http.NewRequest(http.MethodDelete, "/payments/"+strconv.Itoa(int(payment.ID)), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	db.Create(&domain.User{ID: 1})
+	order := domain.Order{UserID: 1, TotalPrice: kzt(1000), Status: domain.OrderStatusPendingPayment}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	failed := &domain.Payment{OrderID: order.ID, UserID: 1, Amount: kzt(1000)}
+	captured := &domain.Payment{OrderID: order.ID, UserID: 1, Amount: kzt(1000)}
+	for _, payment := range []*domain.Payment{failed, captured} {
+		if err := paymentRepo.CreatePayment(payment); err != nil {
+			t.Fatalf("failed to save payment: %v", err)
+		}
+	}
+	assert.NoError(t, paymentRepo.TransitionPayment(failed, domain.PaymentStatusFailed, "declined"))
+	assert.NoError(t, paymentRepo.TransitionPayment(captured, domain.PaymentStatusAuthorized, "authorized"))
+	assert.NoError(t, paymentRepo.TransitionPayment(captured, domain.PaymentStatusCaptured, "captured"))
+
+	assert.Equal(t, http.StatusConflict, remove(captured))
+	assert.Equal(t, http.StatusNoContent, remove(failed))
+	assert.Equal(t, http.StatusNotFound, remove(failed))
+
+	var events int64
+	db.Model(&domain.PaymentEvent{}).Where("payment_id = ?", failed.ID).Count(&events)
+	assert.Equal(t, int64(0), events)
+
+	paid, _ := paymentRepo.TotalPaid(order.ID)
+	assert.Equal(t, int64(1000), paid)
+}
+
+func TestShipOrderAfterPartialRefund(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	shipmentRepo := repository.NewShipmentRepository(db)
+	gateway := service.NewFakeGateway()
+	paymentHandler := handler.NewPaymentHandler(paymentRepo, orderRepo, repository.NewUserRepository(db), gateway, service.NewHomebankSigner("test-secret"))
+
+	router := gin.New()
+	router.POST("/payments/:id/refunds", paymentHandler.RefundPayment)
+
+	db.Create(&domain.User{ID: 1})
+	db.Create(&domain.Product{ID: 1, Price: kzt(1000), Quantity: 5})
+	order := domain.Order{
+		UserID:     1,
+		Items:      []domain.OrderItem{{ProductID: 1, Quantity: 2, UnitPrice: kzt(1000)}},
+		TotalPrice: kzt(2000),
+		Status:     domain.OrderStatusPendingPayment,
+	}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	payment := domain.Payment{OrderID: order.ID, UserID: 1, Amount: kzt(2000), InvoiceID: "000001"}
+	if err := paymentRepo.CreatePayment(&payment); err != nil {
+		t.Fatalf("failed to save payment: %v", err)
+	}
+	result, _ := gateway.Authorize(context.Background(), &service.PaymentRequest{InvoiceID: payment.InvoiceID, Amount: payment.Amount})
+	payment.TransactionID = result.TransactionID
+	assert.NoError(t, gateway.Capture(context.Background(), payment.TransactionID, payment.Amount))
+	assert.NoError(t, paymentRepo.TransitionPayment(&payment, domain.PaymentStatusCaptured, "captured"))
+
+	body, _ := json.Marshal(domain.RefundRequest{Amount: kzt(1000), Reason: "one unit missing"})
+	req, _ := http.NewRequest(http.MethodPost, "/payments/"+strconv.Itoa(int(payment.ID))+"/refunds", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	refunded, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusPaid, refunded.Status)
+
+	shipment, err := shipmentRepo.CreateShipment(order.ID, &domain.ShipmentRequest{Carrier: "kazpost", TrackingNumber: "KZ1", Actor: "warehouse"})
+	if assert.NoError(t, err) {
+		_, err = shipmentRepo.DeliverShipment(shipment.ID, "courier")
+		assert.NoError(t, err)
+	}
+
+	delivered, _ := orderRepo.GetOrderById(order.ID)
+	assert.Equal(t, domain.OrderStatusCompleted, delivered.Status)
+}
+
+func TestCachedTokenSource(t *testing.T) {
+	var fetches int32
+	tokens := service.NewCachedTokenSource(func() (*service.Token, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		if n == 1 {
+			return nil, &service.TransientError{Err: errors.New("connection reset")}
+		}
+		time.Sleep(20 * time.Millisecond)
+		return &service.Token{AccessToken: fmt.Sprintf("token-%d", n), ExpiresAt: time.Now().Add(time.Hour)}, nil
+	})
+	tokens.Backoff = time.Millisecond
+
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = tokens.Token()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+	for _, token := range results {
+		assert.Equal(t, "token-2", token)
+	}
+
+	tokens.Invalidate("token-2")
+	token, err := tokens.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "token-3", token)
+
+	failing := service.NewCachedTokenSource(func() (*service.Token, error) {
+		atomic.AddInt32(&fetches, 1)
+		return nil, errors.New("invalid client")
+	})
+	_, err = failing.Token()
+	assert.Error(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&fetches))
+}
+
+func TestReadinessRequiresPublicKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "public.rsa")
+	gateway := service.NewHomebankGateway(service.HomebankConfig{PublicKeyFile: keyFile, PublicKeyRefresh: time.Hour})
+
+	router := gin.New()
+	router.GET("/readyz", handler.NewHealthHandler(gateway).Ready)
+	ready := func() int {
+		req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, ready())
+
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	assert.Equal(t, http.StatusOK, ready())
+
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	assert.Error(t, gateway.PublicKey.Reload())
+	assert.Equal(t, http.StatusOK, ready())
+
+	key, err := gateway.PublicKey.Key()
+	if assert.NoError(t, err) {
+		assert.True(t, key.Equal(&privateKey.PublicKey))
+	}
+}
+
+func TestHomebankReloadsRotatedPublicKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "public.rsa")
+	writeKey := func() *rsa.PublicKey {
+		privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		der, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+		return &privateKey.PublicKey
+	}
+
+	epay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message":"cryptogram decryption error"}`))
+	}))
+	defer epay.Close()
+
+	writeKey()
+	gateway := service.NewHomebankGateway(service.HomebankConfig{Signer: service.NewHomebankSigner("test-secret"), PublicKeyFile: keyFile, PublicKeyRefresh: time.Hour})
+	gateway.APIURL = epay.URL
+	gateway.Tokens = service.NewCachedTokenSource(func() (*service.Token, error) {
+		return &service.Token{AccessToken: "token", ExpiresAt: time.Now().Add(time.Hour)}, nil
+	})
+	if err := gateway.PublicKey.Reload(); err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+
+	rotated := writeKey()
+	request := &service.PaymentRequest{InvoiceID: "000001", Amount: kzt(1000), Cryptogram: "cryptogram"}
+	_, err := gateway.Authorize(context.Background(), request)
+	var keyErr *service.CardKeyChangedError
+	assert.ErrorAs(t, err, &keyErr)
+
+	key, _ := gateway.PublicKey.Key()
+	assert.True(t, key.Equal(rotated))
+
+	_, err = gateway.Authorize(context.Background(), request)
+	var gatewayErr *domain.PaymentGatewayError
+	assert.ErrorAs(t, err, &gatewayErr)
+	assert.False(t, errors.As(err, &keyErr))
+}
+
+func TestCreatePaymentWithCryptogramOrCardToken(t *testing.T) {
+	db, cleanup := setupTestDB()
+	defer cleanup()
+
+	orderRepo := repository.NewOrderRepository(db)
+	paymentHandler := handler.NewPaymentHandler(repository.NewPaymentRepository(db), orderRepo, repository.NewUserRepository(db), service.NewFakeGateway(), service.NewHomebankSigner("test-secret"))
+
+	router := gin.New()
+	router.POST("/payments", paymentHandler.CreatePayment)
+
+	db.Create(&domain.User{ID: 1})
+	order := domain.Order{UserID: 1, TotalPrice: kzt(1500), Status: domain.OrderStatusPendingPayment}
+	if err := orderRepo.SaveOrder(&order); err != nil {
+		t.Fatalf("failed to save order: %v", err)
+	}
+
+	pay := func(card domain.PaymentCard) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(domain.PaymentCreate{OrderID: order.ID, PaymentCard: card})
+		req, _ := http.NewRequest(http.MethodPost, "/payments", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, pay(domain.PaymentCard{}).Code)
+	assert.Equal(t, http.StatusBadRequest, pay(domain.PaymentCard{Cryptogram: testCard.Cryptogram, CardToken: "card-1"}).Code)
+	assert.Equal(t, http.StatusBadRequest, pay(domain.PaymentCard{Cryptogram: `{"hpan":"4405639704015096","cvc":"815"}`}).Code)
+
+	w := pay(domain.PaymentCard{CardToken: "card-1"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "card-1")
+
+	var stored int64
+	db.Model(&domain.Payment{}).Where("order_id = ?", order.ID).Count(&stored)
+	assert.Equal(t, int64(1), stored)
 }
@@ -1,6 +1,7 @@
 package validation
 
 import (
+	"reflect"
 	"strings"
 
 	"github.com/go-playground/validator/v10"
@@ -10,12 +11,32 @@ var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
+	_ = validate.RegisterValidation("positive_money", positiveMoney)
+}
+
+// positiveMoney checks that a money field has an amount above zero.
+func positiveMoney(fl validator.FieldLevel) bool {
+	field := fl.Field()
+	if field.Kind() != reflect.Struct {
+		return false
+	}
+	amount := field.FieldByName("Amount")
+	return amount.IsValid() && amount.CanInt() && amount.Int() > 0
 }
 
 func ValidateStruct(s interface{}) error {
 	return validate.Struct(s)
 }
 
+// ValidateStructPartial validates only the named fields of s. With no fields
+// there is nothing to validate.
+func ValidateStructPartial(s interface{}, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return validate.StructPartial(s, fields...)
+}
+
 func HandleValidationErrors(validationErrors validator.ValidationErrors, baseMessages map[string]string) string {
 	var errorMessages []string
 	for _, err := range validationErrors {
@@ -2,7 +2,9 @@ package e_commerce
 
 import (
 	"e-commerce/internal/domain"
+	"fmt"
 	"log"
+	"math"
 	"time"
 
 	"gorm.io/driver/postgres"
@@ -38,8 +40,92 @@ func ConnectToDatabase(dbURL string) *gorm.DB {
 }
 
 func AutoMigrate(db *gorm.DB) {
-	err := db.AutoMigrate(&domain.Product{}, &domain.User{}, &domain.Order{}, &domain.Payment{})
+	legacyPayments := db.Migrator().HasTable(&domain.Payment{}) && !db.Migrator().HasColumn(&domain.Payment{}, "status")
+
+	err := db.AutoMigrate(&domain.Product{}, &domain.User{}, &domain.Order{}, &domain.OrderItem{}, &domain.OrderStatusHistory{}, &domain.Payment{}, &domain.PaymentEvent{}, &domain.Refund{}, &domain.Cart{}, &domain.CartItem{}, &domain.Coupon{}, &domain.CouponRedemption{}, &domain.TaxRate{}, &domain.ExchangeRate{}, &domain.OrderTaxLine{}, &domain.ShippingMethod{}, &domain.ShippingRate{}, &domain.Shipment{}, &domain.ShipmentItem{}, &domain.ReturnRequest{}, &domain.ReturnItem{}, &domain.ReturnStatusHistory{}, &domain.IdempotencyKey{})
 	if err != nil {
 		log.Fatalf("Error during migration: %v\n", err)
 	}
+
+	err = db.Model(&domain.Order{}).Where("status = ?", "new").Update("status", domain.OrderStatusPendingPayment).Error
+	if err != nil {
+		log.Fatalf("Error migrating legacy order statuses: %v\n", err)
+	}
+
+	// Payments made before statuses were stored were charged immediately.
+	if legacyPayments {
+		err = db.Model(&domain.Payment{}).Where("1 = 1").Update("status", domain.PaymentStatusCaptured).Error
+		if err != nil {
+			log.Fatalf("Error migrating legacy payment statuses: %v\n", err)
+		}
+	}
+
+	if err = migrateMoneyColumns(db); err != nil {
+		log.Fatalf("Error migrating legacy money columns: %v\n", err)
+	}
+
+	err = db.Exec("CREATE INDEX IF NOT EXISTS idx_orders_base_total ON orders (base_total_amount)").Error
+	if err != nil {
+		log.Fatalf("Error creating order total index: %v\n", err)
+	}
+}
+
+// legacyMoneyColumns lists the float columns that held base currency amounts
+// before money was stored as integer minor units.
+var legacyMoneyColumns = []struct {
+	model   interface{}
+	columns []string
+}{
+	{&domain.Product{}, []string{"price"}},
+	{&domain.Order{}, []string{"subtotal", "discount_total", "tax_total", "shipping_total", "shipping_discount", "total_price"}},
+	{&domain.OrderItem{}, []string{"unit_price", "line_total", "discount"}},
+	{&domain.OrderTaxLine{}, []string{"taxable_amount", "amount"}},
+	{&domain.Payment{}, []string{"amount"}},
+	{&domain.Coupon{}, []string{"min_order_value"}},
+	{&domain.CouponRedemption{}, []string{"discount"}},
+	{&domain.ShippingRate{}, []string{"min_order_total", "max_order_total", "price"}},
+	{&domain.ReturnRequest{}, []string{"refund_amount"}},
+	{&domain.ReturnItem{}, []string{"refund_amount"}},
+}
+
+// migrateMoneyColumns copies legacy float amounts into their money columns and
+// drops the old columns. Tables that were already migrated are skipped.
+func migrateMoneyColumns(db *gorm.DB) error {
+	scale := math.Pow10(domain.CurrencyExponent(domain.BaseCurrency))
+	migrator := db.Migrator()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if migrator.HasColumn(&domain.Coupon{}, "min_order_value") {
+			err := tx.Exec("UPDATE coupons SET amount_amount = ROUND(value * ?) WHERE type = ?", scale, domain.CouponTypeFixedAmount).Error
+			if err != nil {
+				return err
+			}
+		}
+		legacyOrders := migrator.HasColumn(&domain.Order{}, "total_price")
+
+		for _, legacy := range legacyMoneyColumns {
+			stmt := &gorm.Statement{DB: tx}
+			if err := stmt.Parse(legacy.model); err != nil {
+				return err
+			}
+
+			for _, column := range legacy.columns {
+				if !tx.Migrator().HasColumn(legacy.model, column) {
+					continue
+				}
+				query := fmt.Sprintf("UPDATE %s SET %s_amount = ROUND(%s * ?)", stmt.Schema.Table, column, column)
+				if err := tx.Exec(query, scale).Error; err != nil {
+					return err
+				}
+				if err := tx.Migrator().DropColumn(legacy.model, column); err != nil {
+					return err
+				}
+			}
+		}
+
+		if legacyOrders {
+			return tx.Exec("UPDATE orders SET base_total_amount = total_price_amount").Error
+		}
+		return nil
+	})
 }